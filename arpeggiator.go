@@ -0,0 +1,264 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// arpeggiatorSettings controls the realtime arpeggiator. With a Tempo of 0,
+// a note is played every SkillCooldown, otherwise Division notes per whole
+// note at Tempo quarter notes per minute.
+type arpeggiatorSettings struct {
+	Enabled  bool    `json:"enabled"`
+	Pattern  string  `json:"pattern"`
+	Tempo    float64 `json:"tempo"`
+	Division int     `json:"division"`
+	Octaves  int     `json:"octaves"`
+	Latch    bool    `json:"latch"`
+}
+
+// arpeggiatorMinInterval keeps a SkillCooldown of 0 or a very fast tempo from
+// flooding the keystroke queue.
+const arpeggiatorMinInterval = 20 * time.Millisecond
+
+var arpeggiatorPatterns = []string{"up", "down", "up-down", "random", "as-played"}
+
+type arpeggiatorNote struct {
+	Note     uint8
+	Velocity uint8
+	Zone     *keyboardZone
+}
+
+// arpeggiator plays the chord held on the input devices as a pattern, one
+// note at a time.
+type arpeggiator struct {
+	arpeggiatorSettings
+	// chord is in the order the notes were pressed
+	chord    []arpeggiatorNote
+	pressed  map[uint8]bool
+	sounding *arpeggiatorNote
+	step     int
+	timer    clockTimer
+}
+
+func newArpeggiator(clock clock) *arpeggiator {
+	arp := &arpeggiator{
+		arpeggiatorSettings: arpeggiatorSettings{
+			Pattern:  "up",
+			Division: 16,
+			Octaves:  1,
+		},
+		pressed: make(map[uint8]bool),
+		timer:   clock.NewTimer(time.Hour),
+	}
+	arp.timer.Stop()
+	return arp
+}
+
+func (app *application) getArpeggiator() arpeggiatorSettings {
+	return app.arpeggiator.arpeggiatorSettings
+}
+
+func (app *application) setArpeggiator(settings arpeggiatorSettings) error {
+	valid := false
+	for _, i := range arpeggiatorPatterns {
+		if i == settings.Pattern {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("unrecognized arpeggiator pattern %q", settings.Pattern)
+	}
+	if settings.Tempo < 0 || settings.Tempo > 960 {
+		return fmt.Errorf("tempo %g out of range", settings.Tempo)
+	}
+	if settings.Division < 1 || settings.Division > 64 {
+		return fmt.Errorf("division %d out of range", settings.Division)
+	}
+	if settings.Octaves < 1 || settings.Octaves > 4 {
+		return fmt.Errorf("octave range %d out of range", settings.Octaves)
+	}
+	arp := app.arpeggiator
+	if arp.Enabled && (!settings.Enabled || arp.Latch && !settings.Latch) {
+		app.stopArpeggiator()
+	}
+	arp.arpeggiatorSettings = settings
+	return nil
+}
+
+// arpeggiate takes over the notes from the input devices while the
+// arpeggiator is enabled, and reports whether the event is consumed.
+func (app *application) arpeggiate(event *midiQueueEvent) bool {
+	arp := app.arpeggiator
+	message := event.Message
+	// The percussion channel is ignored by filterMidiEvent
+	if len(message) < 2 || message[0]&0x0f == 9 {
+		return false
+	}
+	if message[0]&0xf0 == 0xb0 && message[1] == 0x7b {
+		app.stopArpeggiator()
+		return false
+	}
+	if event.Zone != nil && event.Zone.Route != "game" || len(message) != 3 {
+		return false
+	}
+	switch {
+	case message[0]&0xf0 == 0x90 && message[2] != 0:
+		if message[2] < app.minTriggerVelocity(event) {
+			return true
+		}
+		// With latch, a new chord replaces the previous one
+		if arp.Latch && len(arp.pressed) == 0 {
+			arp.chord = arp.chord[:0]
+		}
+		arp.pressed[message[1]] = true
+		for _, i := range arp.chord {
+			if i.Note == message[1] {
+				return true
+			}
+		}
+		arp.chord = append(arp.chord, arpeggiatorNote{message[1], message[2], event.Zone})
+		if len(arp.chord) == 1 {
+			arp.step = 0
+			arp.timer.Reset(0)
+		}
+	case message[0]&0xf0 == 0x80 || message[0]&0xf0 == 0x90:
+		// The key was pressed before the arpeggiator was enabled
+		if !arp.pressed[message[1]] {
+			return false
+		}
+		delete(arp.pressed, message[1])
+		if arp.Latch {
+			return true
+		}
+		for i, note := range arp.chord {
+			if note.Note == message[1] {
+				arp.chord = append(arp.chord[:i], arp.chord[i+1:]...)
+				break
+			}
+		}
+		if len(arp.chord) == 0 {
+			app.stopArpeggiator()
+		}
+	case message[0]&0xf0 == 0xa0:
+	default:
+		return false
+	}
+	return true
+}
+
+func (app *application) stopArpeggiator() {
+	arp := app.arpeggiator
+	arp.timer.Stop()
+	arp.chord = arp.chord[:0]
+	arp.pressed = make(map[uint8]bool)
+	app.releaseArpeggiatorNote(app.clock.Now())
+}
+
+func (app *application) releaseArpeggiatorNote(now time.Time) {
+	arp := app.arpeggiator
+	if arp.sounding == nil {
+		return
+	}
+	app.addMidiEvent(&midiQueueEvent{
+		Time:        now,
+		Message:     []byte{0x80, arp.sounding.Note, 0x00},
+		Realtime:    true,
+		Zone:        arp.sounding.Zone,
+		Arpeggiated: true,
+	})
+	arp.sounding = nil
+}
+
+// playNextArpeggiatorNote releases the previous note of the pattern and plays
+// the next one.
+func (app *application) playNextArpeggiatorNote(now time.Time) {
+	arp := app.arpeggiator
+	app.releaseArpeggiatorNote(now)
+	sequence := arp.sequence()
+	if len(sequence) == 0 {
+		return
+	}
+	var next arpeggiatorNote
+	if arp.Pattern == "random" {
+		next = sequence[rand.Intn(len(sequence))]
+	} else {
+		next = sequence[arp.step%len(sequence)]
+	}
+	arp.step++
+	arp.sounding = &next
+	app.addMidiEvent(&midiQueueEvent{
+		Time:        now,
+		Message:     []byte{0x90, next.Note, next.Velocity},
+		Realtime:    true,
+		Zone:        next.Zone,
+		Arpeggiated: true,
+	})
+	interval := app.SkillCooldown
+	if arp.Tempo != 0 {
+		interval = time.Duration(float64(time.Minute) / arp.Tempo * 4 / float64(arp.Division))
+	}
+	if interval < arpeggiatorMinInterval {
+		interval = arpeggiatorMinInterval
+	}
+	arp.timer.Reset(interval)
+}
+
+// sequence lists the notes of one cycle of the pattern.
+func (arp *arpeggiator) sequence() []arpeggiatorNote {
+	chord := append([]arpeggiatorNote{}, arp.chord...)
+	if arp.Pattern != "as-played" {
+		sort.Slice(chord, func(i, j int) bool {
+			return chord[i].Note < chord[j].Note
+		})
+	}
+	result := []arpeggiatorNote{}
+	for octave := 0; octave < arp.Octaves; octave++ {
+		for _, i := range chord {
+			if int(i.Note)+12*octave > 0x7f {
+				continue
+			}
+			i.Note += uint8(12 * octave)
+			result = append(result, i)
+		}
+	}
+	switch arp.Pattern {
+	case "down":
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	case "up-down":
+		// The highest and lowest notes are not repeated
+		for i := len(result) - 2; i > 0; i-- {
+			result = append(result, result[i])
+		}
+	}
+	return result
+}
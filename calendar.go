@@ -0,0 +1,369 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The scheduler is armed this long before a calendar entry starts, so the
+// song is loaded and the count-in has time to play
+const calendarPreloadTime = 30 * time.Second
+
+type calendar struct {
+	Entries []calendarEntry
+
+	timer     clockTimer
+	lastArmed time.Time
+	setlist   setlist
+}
+
+type calendarEntry struct {
+	Name       string         `json:"name"`
+	Enabled    bool           `json:"enabled"`
+	StartTime  *float64       `json:"start_time"`
+	Recurrence string         `json:"recurrence"`
+	Songs      []calendarSong `json:"songs"`
+	Gap        float64        `json:"gap"`
+}
+
+type calendarSong struct {
+	File  string `json:"file"`
+	Track uint16 `json:"track"`
+}
+
+type setlist struct {
+	Songs []calendarSong
+	Index int
+	Gap   time.Duration
+}
+
+// cronRule is a standard five-field crontab expression:
+// minute, hour, day of month, month, day of week
+type cronRule struct {
+	minute     [60]bool
+	hour       [24]bool
+	dayOfMonth [32]bool
+	month      [13]bool
+	dayOfWeek  [7]bool
+	anyDay     bool
+	anyWeekday bool
+}
+
+func (app *application) loadCalendar() {
+	if app.CalendarFile == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(app.CalendarFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Error: ", err)
+		}
+		return
+	}
+	var entries []calendarEntry
+	err = json.Unmarshal(data, &entries)
+	if err == nil {
+		err = validateCalendarEntries(entries)
+	}
+	if err != nil {
+		log.Printf("Error: %s\n", err.Error())
+		log.Printf("Unable to load %s, calendar is empty.\n", app.CalendarFile)
+		return
+	}
+	app.calendar.Entries = entries
+	log.Printf("Loaded %d calendar entries.\n", len(entries))
+}
+
+func (app *application) saveCalendar() error {
+	if app.CalendarFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(app.calendar.Entries, "", "    ")
+	if err != nil {
+		return err
+	}
+	tempFile := app.CalendarFile + ".tmp"
+	err = ioutil.WriteFile(tempFile, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFile, app.CalendarFile)
+}
+
+func (app *application) getCalendar() []calendarEntry {
+	return app.calendar.Entries
+}
+
+func (app *application) setCalendar(entries []calendarEntry) error {
+	err := validateCalendarEntries(entries)
+	if err != nil {
+		return err
+	}
+	app.calendar.Entries = entries
+	app.calendar.timer.Reset(0)
+	return app.saveCalendar()
+}
+
+func validateCalendarEntries(entries []calendarEntry) error {
+	for i, entry := range entries {
+		if entry.StartTime == nil && entry.Recurrence == "" {
+			return fmt.Errorf("calendar entry %d has neither start time nor recurrence", i)
+		}
+		if entry.Recurrence != "" {
+			_, err := parseCronRule(entry.Recurrence)
+			if err != nil {
+				return fmt.Errorf("calendar entry %d: %s", i, err.Error())
+			}
+		}
+		if len(entry.Songs) == 0 {
+			return fmt.Errorf("calendar entry %d has no songs", i)
+		}
+		if entry.Gap < 0 {
+			return fmt.Errorf("calendar entry %d has negative gap", i)
+		}
+	}
+	return nil
+}
+
+// nextCalendarEntry returns the earliest start time strictly after the given
+// time, in NTP-corrected time.
+func (app *application) nextCalendarEntry(after time.Time) (index int, startTime time.Time) {
+	index = -1
+	for i, entry := range app.calendar.Entries {
+		if !entry.Enabled {
+			continue
+		}
+		var next time.Time
+		if entry.StartTime != nil {
+			next = unixFloatToTime(*entry.StartTime)
+			if !next.After(after) {
+				continue
+			}
+		} else {
+			rule, err := parseCronRule(entry.Recurrence)
+			if err != nil {
+				continue
+			}
+			next = rule.next(after)
+			if next.IsZero() {
+				continue
+			}
+		}
+		if index == -1 || next.Before(startTime) {
+			index, startTime = i, next
+		}
+	}
+	return
+}
+
+func (app *application) runCalendar(now time.Time) {
+	ntpNow := now.Add(app.NtpClockOffset)
+	after := ntpNow
+	if app.calendar.lastArmed.After(after) {
+		after = app.calendar.lastArmed
+	}
+	index, startTime := app.nextCalendarEntry(after)
+	if index == -1 {
+		return
+	}
+	if startTime.Sub(ntpNow) > calendarPreloadTime {
+		app.calendar.timer.Reset(startTime.Sub(ntpNow) - calendarPreloadTime)
+		return
+	}
+	entry := app.calendar.Entries[index]
+	app.calendar.lastArmed = startTime
+	log.Printf("Calendar: %q starts at %s.\n", entry.Name, startTime.Local().Format("2006-01-02 15:04:05"))
+	app.calendar.setlist = setlist{
+		Songs: entry.Songs,
+		Index: 0,
+		Gap:   time.Duration(entry.Gap*1e9) * time.Nanosecond,
+	}
+	err := app.playSetlistSong(startTime)
+	if err != nil {
+		log.Println("Error: ", err)
+	}
+	app.calendar.timer.Reset(0)
+}
+
+func (app *application) playSetlistSong(startTime time.Time) error {
+	song := app.calendar.setlist.Songs[app.calendar.setlist.Index]
+	f, err := os.Open(song.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = app.setMidiPlaybackFile(f)
+	if err != nil {
+		return err
+	}
+	log.Printf("Loaded %s (%d/%d).\n", song.File, app.calendar.setlist.Index+1, len(app.calendar.setlist.Songs))
+	app.MidiPlaybackTrack = song.Track
+	app.setMidiPlaybackScheduler(true, startTime, false, 0)
+	return nil
+}
+
+// advanceSetlist starts the next song of the current setlist, if any, after
+// the gap configured in the calendar entry.
+func (app *application) advanceSetlist(now time.Time) bool {
+	if app.calendar.setlist.Index+1 >= len(app.calendar.setlist.Songs) {
+		app.calendar.setlist = setlist{}
+		return false
+	}
+	app.calendar.setlist.Index++
+	err := app.playSetlistSong(now.Add(app.NtpClockOffset).Add(app.calendar.setlist.Gap))
+	if err != nil {
+		log.Println("Error: ", err)
+		app.calendar.setlist = setlist{}
+		return false
+	}
+	return true
+}
+
+func parseCronRule(rule string) (*cronRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("recurrence %q must have 5 fields", rule)
+	}
+	r := new(cronRule)
+	var dayOfWeek [8]bool
+	err := parseCronField(fields[0], r.minute[:], 0)
+	if err == nil {
+		err = parseCronField(fields[1], r.hour[:], 0)
+	}
+	if err == nil {
+		err = parseCronField(fields[2], r.dayOfMonth[:], 1)
+	}
+	if err == nil {
+		err = parseCronField(fields[3], r.month[:], 1)
+	}
+	if err == nil {
+		err = parseCronField(fields[4], dayOfWeek[:], 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("recurrence %q: %s", rule, err.Error())
+	}
+	// Both 0 and 7 are Sunday
+	copy(r.dayOfWeek[:], dayOfWeek[:7])
+	r.dayOfWeek[0] = r.dayOfWeek[0] || dayOfWeek[7]
+	r.anyDay = strings.HasPrefix(fields[2], "*")
+	r.anyWeekday = strings.HasPrefix(fields[4], "*")
+	return r, nil
+}
+
+func parseCronField(field string, dest []bool, min int) error {
+	max := len(dest) - 1
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(item, '/'); i != -1 {
+			value, err := strconv.Atoi(item[i+1:])
+			if err != nil || value <= 0 {
+				return fmt.Errorf("invalid step %q", item[i+1:])
+			}
+			step = value
+			item = item[:i]
+		}
+		low, high := min, max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			value, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return fmt.Errorf("invalid value %q", bounds[0])
+			}
+			low, high = value, value
+			if len(bounds) == 2 {
+				value, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return fmt.Errorf("invalid value %q", bounds[1])
+				}
+				high = value
+			} else if step != 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return fmt.Errorf("value %q out of range %d-%d", item, min, max)
+		}
+		for i := low; i <= high; i += step {
+			dest[i] = true
+		}
+	}
+	return nil
+}
+
+func (r *cronRule) matchDay(t time.Time) bool {
+	if !r.month[t.Month()] {
+		return false
+	}
+	dayOfMonth := r.dayOfMonth[t.Day()]
+	dayOfWeek := r.dayOfWeek[t.Weekday()]
+	switch {
+	case r.anyDay && r.anyWeekday:
+		return true
+	case r.anyDay:
+		return dayOfWeek
+	case r.anyWeekday:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
+
+// next returns the first matching minute strictly after the given time, in
+// local time, or zero if there is none in the following five years.
+func (r *cronRule) next(after time.Time) time.Time {
+	t := after.Local().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !r.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.Local)
+			continue
+		}
+		if !r.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.Local)
+			continue
+		}
+		if !r.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func unixFloatToTime(value float64) time.Time {
+	i, f := math.Modf(value)
+	return time.Unix(int64(i), int64(f*1e9))
+}
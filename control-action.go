@@ -0,0 +1,128 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// controlActions are the actions that can be triggered from a MIDI input
+// device, in the order they are laid out on the keys of a control zone.
+var controlActions = []string{
+	"panic",
+	"start",
+	"stop",
+	"pause",
+	"transpose-down",
+	"transpose-up",
+	"next-song",
+	"nudge-earlier",
+	"nudge-later",
+	"reset-octave",
+}
+
+const controlActionNudge = 10 * time.Millisecond
+
+func isControlAction(action string) bool {
+	for _, i := range controlActions {
+		if i == action {
+			return true
+		}
+	}
+	return false
+}
+
+// runControlAction must be called in MidiRealtimeGoro.
+func (app *application) runControlAction(action string) error {
+	var fn func()
+	switch action {
+	case "panic":
+		app.sendAllNoteOff(true)
+		return nil
+	case "reset-octave":
+		_ = app.KeystrokeGoro.SubmitNoWait(app.ctx, func(context.Context) (interface{}, error) {
+			return nil, app.setGameOctave(0)
+		})
+		return nil
+	case "start":
+		fn = func() {
+			app.setMidiPlaybackScheduler(true, app.clock.Now().Add(app.NtpClockOffset), app.MidiPlaybackLoopEnabled, app.MidiPlaybackLoop)
+		}
+	case "stop":
+		fn = func() {
+			app.setMidiPlaybackScheduler(false, app.MidiPlaybackSchedule, app.MidiPlaybackLoopEnabled, app.MidiPlaybackLoop)
+		}
+	case "pause":
+		fn = func() {
+			paused, _ := app.getMidiPlaybackPaused()
+			err := app.setMidiPlaybackPaused(!paused, app.clock.Now())
+			if err != nil {
+				log.Println("Error: ", err)
+			}
+		}
+	case "transpose-down", "transpose-up":
+		semitones := 12
+		if action == "transpose-down" {
+			semitones = -12
+		}
+		fn = func() {
+			settings := app.getPlaybackTranspose()
+			total := settings.Semitones + 12*settings.Octaves + semitones
+			err := app.setPlaybackTranspose(transposeSettings{
+				Semitones: total % 12,
+				Octaves:   total / 12,
+			})
+			if err != nil {
+				log.Println("Error: ", err)
+			}
+		}
+	case "next-song":
+		fn = func() {
+			if !app.advanceSetlist(app.clock.Now()) {
+				log.Println("No next song in the setlist.")
+			}
+		}
+	case "nudge-earlier":
+		fn = func() {
+			app.setMidiPlaybackOffset(app.MidiPlaybackOffset + controlActionNudge)
+		}
+	case "nudge-later":
+		fn = func() {
+			app.setMidiPlaybackOffset(app.MidiPlaybackOffset - controlActionNudge)
+		}
+	default:
+		return fmt.Errorf("unrecognized control action %q", action)
+	}
+	log.Printf("Control action %q.\n", action)
+	_ = app.MidiPlaybackGoro.SubmitNoWait(app.ctx, func(context.Context) (interface{}, error) {
+		fn()
+		return nil, nil
+	})
+	return nil
+}
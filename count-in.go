@@ -0,0 +1,95 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// getCountIn returns the number of clicks in one bar and the interval between
+// them, using the initial tempo and time signature of the song.
+func (app *application) getCountIn() (beatsPerBar int, beatDuration time.Duration) {
+	msPerQuarter := uint32(500000)
+	if len(app.midiFileBuffer.TempoTable) != 0 && app.midiFileBuffer.TempoTable[0].TicksElapsed == 0 {
+		msPerQuarter = app.midiFileBuffer.TempoTable[0].MicrosecondsPerBeat
+	}
+	numerator, denominator := uint8(4), uint8(4)
+	if len(app.midiFileBuffer.TimeSignatureTable) != 0 && app.midiFileBuffer.TimeSignatureTable[0].TicksElapsed == 0 {
+		numerator = app.midiFileBuffer.TimeSignatureTable[0].Numerator
+		denominator = app.midiFileBuffer.TimeSignatureTable[0].Denominator
+	}
+	if numerator == 0 {
+		numerator = 4
+	}
+	return int(numerator), time.Duration(msPerQuarter) * time.Microsecond * 4 / time.Duration(denominator)
+}
+
+func (app *application) setMidiPlaybackCountIn(bars int) {
+	fmt.Printf("Set count-in to %d bars.\n", bars)
+	app.MidiPlaybackCountIn = bars
+	app.midiFileBuffer.countInNextBeat = 0
+	app.midiFileBuffer.countInTimer.Reset(0)
+}
+
+func (app *application) playNextCountInClick(now time.Time) {
+	if !app.MidiPlaybackScheduleEnabled || app.MidiPlaybackPaused || app.MidiPlaybackCountIn <= 0 {
+		return
+	}
+	beatsPerBar, beatDuration := app.getCountIn()
+	totalBeats := app.MidiPlaybackCountIn * beatsPerBar
+	// Clicks are aligned to the NTP-corrected start time, without the offset
+	// of this performer, so everyone in the band counts the same beats
+	countInStart := app.MidiPlaybackSchedule.Add(-app.NtpClockOffset).Add(-time.Duration(totalBeats) * beatDuration)
+	for app.midiFileBuffer.countInNextBeat < totalBeats {
+		beat := app.midiFileBuffer.countInNextBeat
+		beatTime := countInStart.Add(time.Duration(beat) * beatDuration)
+		if beatTime.After(now) {
+			app.midiFileBuffer.countInTimer.Reset(beatTime.Sub(now))
+			return
+		}
+		app.midiFileBuffer.countInNextBeat++
+		if now.Sub(beatTime) > beatDuration/2 {
+			continue
+		}
+		log.Printf("Count-in %d/%d.\n", beat%beatsPerBar+1, beatsPerBar)
+		// Hi Wood Block on downbeats, Low Wood Block otherwise
+		message := []byte{0x99, 0x4d, 0x64}
+		if beat%beatsPerBar == 0 {
+			message = []byte{0x99, 0x4c, 0x7f}
+		}
+		clickTime := beatTime.Add(app.PlaybackExtraDelay)
+		app.queueMidiOut(&midiQueueEvent{
+			Message:  message,
+			Realtime: true,
+		}, clickTime)
+		app.queueMidiOut(&midiQueueEvent{
+			Message:  []byte{0x89, message[1], 0x00},
+			Realtime: true,
+		}, clickTime.Add(beatDuration/2))
+	}
+}
@@ -0,0 +1,258 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// externalSync lets an external sequencer drive the playback with MIDI clock
+// and Song Position Pointer, or with MIDI Time Code, instead of the schedule.
+type externalSync struct {
+	queue   chan externalSyncEvent
+	timeout clockTimer
+	running bool
+	// position in MIDI clocks, 24 per quarter note
+	position  int64
+	mtcPieces [8]uint8
+	mtcNext   uint8
+}
+
+type externalSyncEvent struct {
+	Time    time.Time
+	Message []byte
+}
+
+var externalSyncModes = []string{"off", "clock", "mtc"}
+
+// externalSyncMaxDrift is how far the sequencer may jump before the playback
+// is restarted at its position, instead of following its tempo.
+const externalSyncMaxDrift = 1 * time.Second
+
+// mtcTimeout stops the playback when MIDI Time Code stops arriving, since the
+// sequencer sends no stop message.
+const mtcTimeout = 250 * time.Millisecond
+
+func newExternalSync(clock clock) *externalSync {
+	sync := &externalSync{
+		queue:   make(chan externalSyncEvent, 256),
+		timeout: clock.NewTimer(mtcTimeout),
+	}
+	sync.timeout.Stop()
+	return sync
+}
+
+func isExternalSyncMessage(message []byte) bool {
+	switch message[0] {
+	case 0xf1, 0xf2, 0xf8, 0xfa, 0xfb, 0xfc:
+		return true
+	case 0xf0:
+		return isMtcFullFrame(message)
+	}
+	return false
+}
+
+func isMtcFullFrame(message []byte) bool {
+	return len(message) == 10 && message[1] == 0x7f && message[3] == 0x01 && message[4] == 0x01
+}
+
+// queueExternalSync passes a message from the input devices to the playback
+// goroutine. It never blocks, so a burst of clock messages cannot stall the
+// realtime input.
+func (app *application) queueExternalSync(message []byte, t time.Time) {
+	select {
+	case app.externalSync.queue <- externalSyncEvent{t, message}:
+	default:
+	}
+}
+
+func (app *application) setMidiPlaybackSync(mode string) error {
+	valid := false
+	for _, i := range externalSyncModes {
+		if i == mode {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("unrecognized sync mode %q", mode)
+	}
+	log.Printf("Set playback sync to %s.\n", mode)
+	app.MidiPlaybackSync = mode
+	app.externalSync.running = false
+	app.externalSync.position = 0
+	app.externalSync.mtcNext = 0
+	app.externalSync.timeout.Stop()
+	app.midiFileBuffer.clockOutTimer.Reset(0)
+	return nil
+}
+
+func (app *application) onExternalSync(event externalSyncEvent) {
+	sync := app.externalSync
+	message := event.Message
+	switch app.MidiPlaybackSync {
+	case "clock":
+		switch message[0] {
+		case 0xfa:
+			sync.running = true
+			sync.position = 0
+			app.seekExternalSync(0, event.Time)
+		case 0xfb:
+			sync.running = true
+			app.seekExternalSync(app.clocksToProgress(sync.position), event.Time)
+		case 0xfc:
+			if sync.running {
+				sync.running = false
+				app.stopExternalSync()
+			}
+		case 0xf2:
+			// Song Position Pointer counts sixteenth notes, 6 MIDI clocks each
+			sync.position = (int64(message[1]) | int64(message[2])<<7) * 6
+			if sync.running {
+				app.seekExternalSync(app.clocksToProgress(sync.position), event.Time)
+			}
+		case 0xf8:
+			if sync.running {
+				sync.position++
+				app.followExternalSync(app.clocksToProgress(sync.position), event.Time)
+			}
+		}
+	case "mtc":
+		switch message[0] {
+		case 0xf1:
+			piece := message[1] >> 4
+			// Piece 0 always starts a new time code, so that a lost piece
+			// does not cost a whole cycle
+			if piece != sync.mtcNext && piece != 0 {
+				sync.mtcNext = 0
+				return
+			}
+			sync.mtcPieces[piece] = message[1] & 0x0f
+			sync.mtcNext = (piece + 1) % 8
+			if piece != 7 {
+				return
+			}
+			sync.timeout.Reset(mtcTimeout)
+			p := sync.mtcPieces
+			hours := int(p[6]|p[7]<<4) & 0x1f
+			rate := p[7] >> 1 & 0x03
+			// A full time code is spread over two frames
+			frames := int(p[0]|p[1]<<4) + 2
+			progress := mtcToDuration(hours, int(p[4]|p[5]<<4), int(p[2]|p[3]<<4), frames, rate)
+			if !sync.running {
+				sync.running = true
+				app.seekExternalSync(progress, event.Time)
+				return
+			}
+			app.followExternalSync(progress, event.Time)
+		case 0xf0:
+			progress := mtcToDuration(int(message[5]&0x1f), int(message[6]), int(message[7]), int(message[8]), message[5]>>5&0x03)
+			if sync.running {
+				app.seekExternalSync(progress, event.Time)
+			}
+		}
+	}
+}
+
+func (app *application) onExternalSyncTimeout() {
+	if app.MidiPlaybackSync == "mtc" && app.externalSync.running {
+		app.externalSync.running = false
+		app.externalSync.mtcNext = 0
+		app.stopExternalSync()
+	}
+}
+
+func mtcToDuration(hours, minutes, seconds, frames int, rate uint8) time.Duration {
+	framesPerSecond := [4]float64{24, 25, 29.97, 30}[rate]
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second + time.Duration(float64(frames)/framesPerSecond*1e9)*time.Nanosecond
+}
+
+// clocksToProgress converts a position in MIDI clocks to a position in the
+// song, following its tempo map.
+func (app *application) clocksToProgress(clocks int64) time.Duration {
+	ticksPerBeat := int64(app.midiFileBuffer.TicksPerBeat)
+	if ticksPerBeat == 0 {
+		return 0
+	}
+	ticks := clocks * ticksPerBeat / 24
+	lastTicks := int64(0)
+	elapsed := time.Duration(0)
+	msPerBeat := uint32(500000)
+	for _, entry := range app.midiFileBuffer.TempoTable {
+		if entry.TicksElapsed > ticks {
+			break
+		}
+		elapsed += time.Duration(entry.TicksElapsed-lastTicks) * time.Duration(msPerBeat) * time.Microsecond / time.Duration(ticksPerBeat)
+		lastTicks, msPerBeat = entry.TicksElapsed, entry.MicrosecondsPerBeat
+	}
+	return elapsed + time.Duration(ticks-lastTicks)*time.Duration(msPerBeat)*time.Microsecond/time.Duration(ticksPerBeat)
+}
+
+// seekExternalSync starts the playback at a position in the song, as if the
+// scheduler had been set to the right start time.
+func (app *application) seekExternalSync(progress time.Duration, now time.Time) {
+	log.Printf("External sync: start at %s.\n", progress)
+	app.setMidiPlaybackScheduler(true, now.Add(app.NtpClockOffset).Add(app.MidiPlaybackOffset).Add(-progress), false, 0)
+	if progress <= 0 {
+		// Nothing to skip, so the first notes are not swallowed by fast-forward
+		app.midiFileBuffer.fastForward = false
+	}
+}
+
+func (app *application) stopExternalSync() {
+	log.Println("External sync: stop.")
+	app.setMidiPlaybackScheduler(false, app.MidiPlaybackSchedule, false, 0)
+}
+
+// followExternalSync moves the schedule so that the playback keeps up with
+// the tempo of the sequencer. The position never goes back before the last
+// note played, so no note is played twice.
+func (app *application) followExternalSync(progress time.Duration, now time.Time) {
+	if !app.MidiPlaybackScheduleEnabled || app.MidiPlaybackPaused {
+		return
+	}
+	drift := now.Add(app.NtpClockOffset).Add(app.MidiPlaybackOffset).Sub(app.MidiPlaybackSchedule) - progress
+	if drift > externalSyncMaxDrift || drift < -externalSyncMaxDrift {
+		app.seekExternalSync(progress, now)
+		return
+	}
+	track := app.MidiPlaybackTrack
+	if len(app.midiFileBuffer.MidiTracks) == 1 {
+		track = 0
+	}
+	if int(track) < len(app.midiFileBuffer.MidiTracks) {
+		thisTrack := app.midiFileBuffer.MidiTracks[track]
+		index := app.midiFileBuffer.nextEventIndex
+		if index > 0 && index <= len(thisTrack) {
+			if lastNoteProgress := thisTrack[index-1].Microseconds.Duration(); progress < lastNoteProgress {
+				progress = lastNoteProgress
+			}
+		}
+	}
+	app.MidiPlaybackSchedule = now.Add(app.NtpClockOffset).Add(app.MidiPlaybackOffset).Add(-progress)
+	app.midiFileBuffer.nextEventTimer.Reset(0)
+}
@@ -0,0 +1,154 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+type humanizeSettings struct {
+	Enabled  bool    `json:"enabled"`
+	Jitter   float64 `json:"jitter"`
+	Emphasis uint8   `json:"emphasis"`
+	Seed     int64   `json:"seed"`
+}
+
+const humanizeMaxJitter = 100 * time.Millisecond
+
+func (app *application) getHumanize() humanizeSettings {
+	return app.midiFileBuffer.SongSettings.Humanize
+}
+
+func (app *application) setHumanize(settings humanizeSettings) error {
+	if app.midiFileBuffer.SongHash == "" {
+		return errors.New("no MIDI file loaded")
+	}
+	if settings.Jitter < 0 || time.Duration(settings.Jitter*1e9) > humanizeMaxJitter {
+		return fmt.Errorf("jitter must be between 0 and %s", humanizeMaxJitter)
+	}
+	if settings.Emphasis > 0x7f {
+		return errors.New("emphasis must be between 0 and 127")
+	}
+	oldSettings := app.midiFileBuffer.SongSettings.Humanize
+	app.midiFileBuffer.SongSettings.Humanize = settings
+	err := app.saveSongSettings()
+	if err != nil {
+		app.midiFileBuffer.SongSettings.Humanize = oldSettings
+		return err
+	}
+	fmt.Printf("Set humanization to %+v.\n", settings)
+	app.loadMidiTracks()
+	app.resetMidiPlayback()
+	return nil
+}
+
+// humanizeMidiTracks returns a copy of the tracks where each note is moved by
+// a random amount of time, and notes on downbeats are played louder. A note
+// is never moved before the end of the previous note of the same pitch.
+func (app *application) humanizeMidiTracks(tracks []midiFileTrack) []midiFileTrack {
+	settings := app.midiFileBuffer.SongSettings.Humanize
+	if !settings.Enabled {
+		return tracks
+	}
+	seed := settings.Seed
+	if songHash, err := hex.DecodeString(app.midiFileBuffer.SongHash); err == nil && len(songHash) >= 8 {
+		seed ^= int64(binary.BigEndian.Uint64(songHash))
+	}
+	random := rand.New(rand.NewSource(seed))
+	jitter := int64(settings.Jitter * 1e6)
+
+	result := make([]midiFileTrack, len(tracks))
+	for trackID, track := range tracks {
+		humanized := make(midiFileTrack, len(track))
+		var noteOffsets, lastNoteEnds [16][128]int64
+		for i, event := range track {
+			humanizedEvent := *event
+			humanized[i] = &humanizedEvent
+			message := event.Message
+			if len(message) != 3 || (message[0]&0xf0 != 0x80 && message[0]&0xf0 != 0x90) {
+				continue
+			}
+			channel, note := message[0]&0xf, message[1]&0x7f
+			denominator := int64(event.Microseconds.Denominator)
+			if message[0]&0xf0 == 0x90 && message[2] != 0 {
+				offset := int64(0)
+				if jitter != 0 {
+					offset = (random.Int63n(2*jitter+1) - jitter) * denominator
+				}
+				if event.Microseconds.Numerator+offset < lastNoteEnds[channel][note] {
+					offset = lastNoteEnds[channel][note] - event.Microseconds.Numerator
+				}
+				if event.Microseconds.Numerator+offset < 0 {
+					offset = -event.Microseconds.Numerator
+				}
+				noteOffsets[channel][note] = offset
+				humanizedEvent.Microseconds.Numerator += offset
+				if settings.Emphasis != 0 && channel != 9 && app.isDownbeat(event.TicksElapsed) {
+					velocity := int(message[2]) + int(settings.Emphasis)
+					if velocity > 0x7f {
+						velocity = 0x7f
+					}
+					humanizedEvent.Message = []byte{message[0], message[1], uint8(velocity)}
+				}
+			} else {
+				humanizedEvent.Microseconds.Numerator += noteOffsets[channel][note]
+				lastNoteEnds[channel][note] = humanizedEvent.Microseconds.Numerator
+			}
+		}
+		sort.SliceStable(humanized, func(i, j int) bool {
+			return humanized[i].Microseconds.Numerator < humanized[j].Microseconds.Numerator
+		})
+		result[trackID] = humanized
+	}
+	return result
+}
+
+func (app *application) isDownbeat(ticks int64) bool {
+	ticksPerBeat := int64(app.midiFileBuffer.TicksPerBeat)
+	if ticksPerBeat == 0 {
+		return false
+	}
+	barStart := int64(0)
+	numerator, denominator := int64(4), int64(4)
+	for _, entry := range app.midiFileBuffer.TimeSignatureTable {
+		if entry.TicksElapsed > ticks {
+			break
+		}
+		barStart = entry.TicksElapsed
+		numerator, denominator = int64(entry.Numerator), int64(entry.Denominator)
+		if numerator == 0 {
+			numerator = 4
+		}
+	}
+	ticksPerBar, _ := timeSignatureTicks(ticksPerBeat, numerator, denominator)
+	return (ticks-barStart)%ticksPerBar == 0
+}
@@ -53,8 +53,16 @@ const (
 	RIGHT_CTRL_PRESSED uint32 = 0x0004
 	RIGHT_ALT_PRESSED  uint32 = 0x0001
 	SHIFT_PRESSED      uint32 = 0x0010
+
+	CTRL_C_EVENT        uint32 = 0
+	CTRL_BREAK_EVENT    uint32 = 1
+	CTRL_CLOSE_EVENT    uint32 = 2
+	CTRL_LOGOFF_EVENT   uint32 = 5
+	CTRL_SHUTDOWN_EVENT uint32 = 6
 )
 
+type HandlerRoutine func(dwCtrlType uint32) uintptr
+
 type INPUT_RECORD_KEY_EVENT struct {
 	EventType uint16
 	KeyEvent  KEY_EVENT_RECORD
@@ -70,13 +78,14 @@ type KEY_EVENT_RECORD struct {
 }
 
 var (
-	kernel32          *windows.LazyDLL
-	getConsoleMode    *windows.LazyProc
-	getCurrentProcess *windows.LazyProc
-	getStdHandle      *windows.LazyProc
-	readConsoleInput  *windows.LazyProc
-	setConsoleMode    *windows.LazyProc
-	setPriorityClass  *windows.LazyProc
+	kernel32              *windows.LazyDLL
+	getConsoleMode        *windows.LazyProc
+	getCurrentProcess     *windows.LazyProc
+	getStdHandle          *windows.LazyProc
+	readConsoleInput      *windows.LazyProc
+	setConsoleCtrlHandler *windows.LazyProc
+	setConsoleMode        *windows.LazyProc
+	setPriorityClass      *windows.LazyProc
 )
 
 func init() {
@@ -85,6 +94,7 @@ func init() {
 	getCurrentProcess = kernel32.NewProc("GetCurrentProcess")
 	getStdHandle = kernel32.NewProc("GetStdHandle")
 	readConsoleInput = kernel32.NewProc("ReadConsoleInputW")
+	setConsoleCtrlHandler = kernel32.NewProc("SetConsoleCtrlHandler")
 	setConsoleMode = kernel32.NewProc("SetConsoleMode")
 	setPriorityClass = kernel32.NewProc("SetPriorityClass")
 }
@@ -115,6 +125,21 @@ func ReadConsoleInput(hConsoleInput uintptr, lpBuffer []INPUT_RECORD_KEY_EVENT,
 	return true, lpNumberOfEventsRead, nil
 }
 
+func SetConsoleCtrlHandler(handlerRoutine HandlerRoutine, add bool) (err error) {
+	var lpHandlerRoutine, bAdd uintptr
+	if handlerRoutine != nil {
+		lpHandlerRoutine = windows.NewCallback(handlerRoutine)
+	}
+	if add {
+		bAdd = 1
+	}
+	r1, _, err := setConsoleCtrlHandler.Call(lpHandlerRoutine, bAdd)
+	if int32(r1) == 0 {
+		return
+	}
+	return nil
+}
+
 func SetConsoleMode(hConsoleHandle uintptr, dwMode uint32) (bResult bool, err error) {
 	r1, _, err := setConsoleMode.Call(hConsoleHandle, uintptr(dwMode))
 	if int32(r1) == 0 {
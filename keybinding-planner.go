@@ -0,0 +1,98 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+// A note may be bound to several keys in the game, for example both Shift-Q
+// and A to C5. The planner picks the binding that can be pressed with the
+// fewest modifier changes, so that fewer notes wait for ModifierCooldown.
+
+func (app *application) keybindingCandidates(note int) []*keybindingPreset {
+	profile := app.activeKeybindingProfile()
+	result := []*keybindingPreset{}
+	if profile.Keybinding[note].VirtualKeyCode != 0 {
+		result = append(result, &profile.Keybinding[note])
+	}
+	for i := range profile.Alternatives[note] {
+		if profile.Alternatives[note][i].VirtualKeyCode != 0 {
+			result = append(result, &profile.Alternatives[note][i])
+		}
+	}
+	return result
+}
+
+// chooseKeybinding returns nil if the note has no binding. On a tie, the
+// Keybinding line goes before the KeybindingAlternative lines.
+func (app *application) chooseKeybinding(note int, current *keybindingPreset, pressedKeys *[256]keystroke) *keybindingPreset {
+	var result *keybindingPreset
+	bestCost := 0
+	for _, keybind := range app.keybindingCandidates(note) {
+		cost := 0
+		if app.octaveShiftEnabled() {
+			octaveShift := keybindOctave(keybind) - keybindOctave(current)
+			if octaveShift < 0 {
+				octaveShift = -octaveShift
+			}
+			cost += 2 * octaveShift
+		} else if keybind.Ctrl != current.Ctrl || keybind.Alt != current.Alt || keybind.Shift != current.Shift {
+			cost += 2
+		}
+		// Pressing a key held for another note would cut that note short
+		if pressedKeys[keybind.VirtualKeyCode].Pressed && pressedKeys[keybind.VirtualKeyCode].MidiNote != uint8(note) {
+			cost++
+		}
+		if result == nil || cost < bestCost {
+			result, bestCost = keybind, cost
+		}
+	}
+	return result
+}
+
+// pressedKeybinding returns the binding that is currently held for note, or
+// nil if the note is not held.
+func (app *application) pressedKeybinding(note int, pressedKeys *[256]keystroke) *keybindingPreset {
+	for _, keybind := range app.keybindingCandidates(note) {
+		if pressedKeys[keybind.VirtualKeyCode].Pressed && pressedKeys[keybind.VirtualKeyCode].MidiNote == uint8(note) {
+			return keybind
+		}
+	}
+	return nil
+}
+
+// currentModifiers describes the modifiers held in the game, or the octave of
+// the game in octave shift mode, as a keybinding.
+func (app *application) currentModifiers() *keybindingPreset {
+	if app.octaveShiftEnabled() {
+		return &keybindingPreset{
+			Ctrl:  app.keyStatus.octave < 0,
+			Shift: app.keyStatus.octave > 0,
+		}
+	}
+	return &keybindingPreset{
+		Ctrl:  app.keyStatus.ctrl.Pressed,
+		Alt:   app.keyStatus.alt.Pressed,
+		Shift: app.keyStatus.shift.Pressed,
+	}
+}
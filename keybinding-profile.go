@@ -0,0 +1,95 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+)
+
+// keybindingProfile is a named keybinding map, which can be switched to at
+// runtime. The keybindings outside of any profile section in the config file
+// make up the profile named "default". A profile is never modified after the
+// config file is loaded, so it can be shared between goroutines.
+type keybindingProfile struct {
+	Name         string
+	Keybinding   [128]keybindingPreset
+	Alternatives [128][]keybindingPreset
+}
+
+const defaultKeybindingProfile = "default"
+
+func (app *application) addDefaultKeybindingProfile() error {
+	for _, profile := range app.KeybindingProfiles {
+		if profile.Name == defaultKeybindingProfile {
+			return fmt.Errorf("keybinding profile %q is reserved", defaultKeybindingProfile)
+		}
+	}
+	app.KeybindingProfiles = append([]*keybindingProfile{{
+		Name:         defaultKeybindingProfile,
+		Keybinding:   app.Keybinding,
+		Alternatives: app.KeybindingAlternatives,
+	}}, app.KeybindingProfiles...)
+	app.activeKeybindings = app.KeybindingProfiles[0]
+	return nil
+}
+
+// activeKeybindingProfile may be called from any goroutine.
+func (app *application) activeKeybindingProfile() *keybindingProfile {
+	app.keybindingMutex.RLock()
+	defer app.keybindingMutex.RUnlock()
+	return app.activeKeybindings
+}
+
+func (app *application) getKeybindingProfiles() []string {
+	result := make([]string, len(app.KeybindingProfiles))
+	for i, profile := range app.KeybindingProfiles {
+		result[i] = profile.Name
+	}
+	return result
+}
+
+// setKeybindingProfile must run on the keystroke goroutine. Every held key and
+// modifier is released before switching, since the new profile may bind the
+// same note to a different key.
+func (app *application) setKeybindingProfile(name string) error {
+	var profile *keybindingProfile
+	for _, i := range app.KeybindingProfiles {
+		if i.Name == name {
+			profile = i
+			break
+		}
+	}
+	if profile == nil {
+		return fmt.Errorf("unrecognized keybinding profile %q", name)
+	}
+	app.releaseAllInputs(app.clock.Now(), "keybinding profile switch")
+	app.keybindingMutex.Lock()
+	app.activeKeybindings = profile
+	app.keybindingMutex.Unlock()
+	app.KeybindingProfile = profile.Name
+	fmt.Printf("Switched to keybinding profile %s.\n", profile.Name)
+	return nil
+}
@@ -0,0 +1,127 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// keyboardZone is a range of notes on the input devices. Zones may overlap,
+// in which case a note is played in every zone it falls in. When no zone is
+// defined, the whole keyboard is played in the game.
+type keyboardZone struct {
+	Name        string `json:"name"`
+	Lowest      uint8  `json:"lowest"`
+	Highest     uint8  `json:"highest"`
+	Channel     int    `json:"channel"` // 1 to 16, or 0 for all channels
+	Transpose   int    `json:"transpose"`
+	MinVelocity uint8  `json:"min_velocity"`
+	Route       string `json:"route"` // "game", "echo" or "control"
+}
+
+func validateKeyboardZones(zones []keyboardZone) error {
+	names := make(map[string]bool)
+	for _, zone := range zones {
+		if zone.Name == "" || strings.ContainsAny(zone.Name, " \t") {
+			return fmt.Errorf("invalid keyboard zone name %q", zone.Name)
+		}
+		if names[zone.Name] {
+			return fmt.Errorf("duplicate keyboard zone %q", zone.Name)
+		}
+		names[zone.Name] = true
+		if zone.Lowest > zone.Highest || zone.Highest > 0x7f {
+			return fmt.Errorf("invalid note range in keyboard zone %q", zone.Name)
+		}
+		if zone.Channel < 0 || zone.Channel > 16 {
+			return fmt.Errorf("channel %d out of range in keyboard zone %q", zone.Channel, zone.Name)
+		}
+		if zone.Transpose < -0x7f || zone.Transpose > 0x7f {
+			return fmt.Errorf("transpose %d out of range in keyboard zone %q", zone.Transpose, zone.Name)
+		}
+		if zone.MinVelocity > 0x7f {
+			return fmt.Errorf("velocity %d out of range in keyboard zone %q", zone.MinVelocity, zone.Name)
+		}
+		if zone.Route != "game" && zone.Route != "echo" && zone.Route != "control" {
+			return fmt.Errorf("route of keyboard zone %q must be game, echo or control", zone.Name)
+		}
+	}
+	return nil
+}
+
+func (app *application) getKeyboardZones() []keyboardZone {
+	return append([]keyboardZone{}, app.KeyboardZones...)
+}
+
+func (app *application) setKeyboardZones(zones []keyboardZone) error {
+	err := validateKeyboardZones(zones)
+	if err != nil {
+		return err
+	}
+	app.sendAllNoteOff(true)
+	app.KeyboardZones = zones
+	return nil
+}
+
+func (zone *keyboardZone) match(message []byte) bool {
+	if zone.Channel != 0 && int(message[0]&0x0f)+1 != zone.Channel {
+		return false
+	}
+	return message[1] >= zone.Lowest && message[1] <= zone.Highest
+}
+
+// addZonedMidiEvent plays a note from the input devices in every zone it
+// falls in. The keys of a control zone trigger the control actions in order,
+// starting from its lowest note.
+func (app *application) addZonedMidiEvent(event *midiQueueEvent) {
+	for i := range app.KeyboardZones {
+		zone := &app.KeyboardZones[i]
+		if !zone.match(event.Message) {
+			continue
+		}
+		if zone.Route == "control" {
+			if event.Message[0]&0xf0 == 0x90 && event.Message[2] != 0 && event.Message[2] >= zone.MinVelocity {
+				index := int(event.Message[1] - zone.Lowest)
+				if index < len(controlActions) {
+					_ = app.runControlAction(controlActions[index])
+				}
+			}
+			continue
+		}
+		note := int(event.Message[1]) + zone.Transpose
+		if note < 0x00 || note > 0x7f {
+			continue
+		}
+		message := append([]byte{}, event.Message...)
+		message[1] = uint8(note)
+		app.addMidiEvent(&midiQueueEvent{
+			Time:     event.Time,
+			Message:  message,
+			Realtime: event.Realtime,
+			Zone:     zone,
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const keystrokeLogSize = 1024
+
+// keystrokeLogEntry records one decision of the keystroke goroutine, so that a
+// missed note can be explained afterwards.
+type keystrokeLogEntry struct {
+	Seq        uint64
+	Time       time.Time
+	Intended   time.Time
+	Action     string
+	Note       int
+	Realtime   bool
+	VirtualKey uint8
+	Ctrl       bool
+	Alt        bool
+	Shift      bool
+	Wait       time.Duration
+	Reason     string
+}
+
+// keystrokeLog is a ring buffer of the most recent entries. It is written by
+// the keystroke goroutine and read by the web server.
+type keystrokeLog struct {
+	mutex   sync.Mutex
+	entries [keystrokeLogSize]keystrokeLogEntry
+	nextSeq uint64
+	changed chan struct{}
+}
+
+func newKeystrokeLog() *keystrokeLog {
+	return &keystrokeLog{
+		nextSeq: 1,
+		changed: make(chan struct{}),
+	}
+}
+
+// logKeystroke adds an entry. Note is -1 if the action is not about a single
+// note, and event or keybind may be nil.
+func (app *application) logKeystroke(action string, event *midiQueueEvent, note int, keybind *keybindingPreset, wait time.Duration, reason string) {
+	if app.keystrokeLog == nil {
+		return
+	}
+	entry := keystrokeLogEntry{
+		Time:   app.clock.Now(),
+		Action: action,
+		Note:   note,
+		Wait:   wait,
+		Reason: reason,
+	}
+	if event != nil {
+		entry.Intended = event.Time
+		entry.Realtime = event.Realtime
+	}
+	if keybind != nil {
+		entry.VirtualKey = keybind.VirtualKeyCode
+		entry.Ctrl, entry.Alt, entry.Shift = keybind.Ctrl, keybind.Alt, keybind.Shift
+	}
+	l := app.keystrokeLog
+	l.mutex.Lock()
+	entry.Seq = l.nextSeq
+	l.entries[entry.Seq%keystrokeLogSize] = entry
+	l.nextSeq++
+	close(l.changed)
+	l.changed = make(chan struct{})
+	l.mutex.Unlock()
+}
+
+// since returns the entries after seq that are still in the buffer, and a
+// channel that is closed when the next entry is added.
+func (l *keystrokeLog) since(seq uint64) ([]keystrokeLogEntry, <-chan struct{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	first := seq + 1
+	if l.nextSeq > keystrokeLogSize && first < l.nextSeq-keystrokeLogSize {
+		first = l.nextSeq - keystrokeLogSize
+	}
+	result := []keystrokeLogEntry{}
+	for i := first; i < l.nextSeq; i++ {
+		result = append(result, l.entries[i%keystrokeLogSize])
+	}
+	return result, l.changed
+}
@@ -51,14 +51,30 @@ type keystrokeStatus struct {
 	lastNote            uint8
 	lastNoteTime        time.Time
 	lastModifierTime    time.Time
-	clearModifiersTimer *time.Timer
+	clearModifiersTimer clockTimer
+	stuckKeyTimer       clockTimer
+	octave              int
+	pendingModifiers    *keybindingPreset
+	pendingExpiry       time.Time
+	playbackCounters    playbackCounters
+	rateLimiter         keyRateLimiter
+}
+
+// playbackCounters counts what happened to the note-on events of MIDI file
+// playback since the scheduler was last changed.
+type playbackCounters struct {
+	Sent    int
+	Delayed int
+	Dropped int
 }
 
 func (app *application) processKeystrokes() {
 	app.keyStatus = &keystrokeStatus{
-		clearModifiersTimer: time.NewTimer(app.IdleDuration),
+		clearModifiersTimer: app.clock.NewTimer(app.IdleDuration),
+		stuckKeyTimer:       app.clock.NewTimer(app.MaxKeyHoldDuration),
 		lastNote:            0xff,
 	}
+	defer app.releaseKeysOnExit()
 	for {
 		select {
 		case r, ok := <-app.KeystrokeGoro:
@@ -69,8 +85,10 @@ func (app *application) processKeystrokes() {
 		case nextAction := <-app.keystrokeQueue.NextAction():
 			nextEvent := nextAction.Value.(*midiQueueEvent)
 			app.produceKeystroke(nextEvent)
-		case now := <-app.keyStatus.clearModifiersTimer.C:
+		case now := <-app.keyStatus.clearModifiersTimer.Chan():
 			app.clearModifiers(now)
+		case now := <-app.keyStatus.stuckKeyTimer.Chan():
+			app.releaseStuckKeys(now)
 		case <-app.ctx.Done():
 			return
 		}
@@ -79,12 +97,20 @@ func (app *application) processKeystrokes() {
 
 func (app *application) produceKeystroke(event *midiQueueEvent) {
 	pInputs := []user32.INPUT_KEYBDINPUT{}
-	now := time.Now()
+	now := app.clock.Now()
+	if !event.Expiry.IsZero() && now.After(event.Expiry) {
+		app.dropExpiredKeystroke(event, now)
+		return
+	}
+	if event.PrepareModifiers {
+		app.prepareModifiers(event, now)
+		return
+	}
 	if event.Message[0] == 0x80 {
 		if event.Realtime {
-			app.midiOutQueue.AddAction(event, now)
+			app.queueMidiOut(event, now)
 		} else {
-			app.midiOutQueue.AddAction(event, now.Add(app.PlaybackExtraDelay))
+			app.queueMidiOut(event, now.Add(app.PlaybackExtraDelay))
 		}
 		note := int(event.Message[1])
 		if event.AlreadyTransposed {
@@ -93,11 +119,10 @@ func (app *application) produceKeystroke(event *midiQueueEvent) {
 				return
 			}
 		}
-		keybind := &app.Keybinding[note]
-		if keybind.VirtualKeyCode == 0 {
+		if len(app.keybindingCandidates(note)) == 0 {
 			return
 		}
-		if app.keyStatus.pressedKeys[keybind.VirtualKeyCode].Pressed && app.keyStatus.pressedKeys[keybind.VirtualKeyCode].MidiNote == uint8(note) {
+		if keybind := app.pressedKeybinding(note, &app.keyStatus.pressedKeys); keybind != nil {
 			pInputs = append(pInputs, user32.INPUT_KEYBDINPUT{
 				Type: user32.INPUT_KEYBOARD,
 				Ki: user32.KEYBDINPUT{
@@ -111,25 +136,60 @@ func (app *application) produceKeystroke(event *midiQueueEvent) {
 			app.keyStatus.pressedKeys[keybind.VirtualKeyCode].Pressed = false
 			app.keyStatus.pressedKeys[keybind.VirtualKeyCode].LastRelease = now
 			app.keyStatus.pressedKeysCount--
+			app.logKeystroke("release", event, note, keybind, 0, "")
 		}
 		if app.keyStatus.pressedKeysCount == 0 {
+			pendingModifiers := app.keyStatus.pendingModifiers
+			app.keyStatus.pendingModifiers = nil
+			if pendingModifiers != nil && now.Before(app.keyStatus.pendingExpiry) {
+				// Release the key before changing modifiers
+				if len(pInputs) != 0 {
+					app.sendKeystrokes(pInputs)
+				}
+				pInputs, _ = app.changeModifiers(pendingModifiers, now)
+			}
 			app.keyStatus.clearModifiersTimer.Reset(app.IdleDuration)
 		}
 	} else if event.Message[0] == 0x90 {
 		app.keyStatus.clearModifiersTimer.Stop()
+		app.keyStatus.pendingModifiers = nil
 		note := int(event.Message[1])
 		if event.AlreadyTransposed {
 			note -= app.MidiOutTranspose
 			if note < 0x00 || note > 0x7f {
+				app.logKeystroke("drop", event, -1, nil, 0, "out of range after transpose")
+				if !event.Realtime {
+					app.keyStatus.playbackCounters.Dropped++
+				}
 				return
 			}
 		}
-		keybind := &app.Keybinding[note]
-		if keybind.VirtualKeyCode == 0 {
+		keybind := app.chooseKeybinding(note, app.currentModifiers(), &app.keyStatus.pressedKeys)
+		if keybind == nil {
 			noteName, _ := noteIndexToName(uint8(note))
 			log.Printf("Note %s out of range.\n", noteName)
+			app.logKeystroke("drop", event, note, nil, 0, "no keybinding")
+			if !event.Realtime {
+				app.keyStatus.playbackCounters.Dropped++
+			}
 			return
 		}
+		if waitTime := app.keyStatus.rateLimiter.wait(now, app.KeyRateLimit, app.KeyRateBurst); waitTime != 0 {
+			if app.KeyRatePolicy == "drop" {
+				log.Println("Key rate limit exceeded, note dropped.")
+				app.logKeystroke("drop", event, note, keybind, 0, "rate limit")
+				app.keyStatus.rateLimiter.Dropped++
+				if !event.Realtime {
+					app.keyStatus.playbackCounters.Dropped++
+				}
+				return
+			}
+			log.Printf("Key rate limit sleep %s.\n", waitTime)
+			app.logKeystroke("wait", event, note, keybind, waitTime, "rate limit")
+			app.keyStatus.rateLimiter.Delayed++
+			app.clock.Sleep(waitTime)
+			now = now.Add(waitTime)
+		}
 		if app.keyStatus.pressedKeys[keybind.VirtualKeyCode].Pressed {
 			pInputs = append(pInputs, user32.INPUT_KEYBDINPUT{
 				Type: user32.INPUT_KEYBOARD,
@@ -145,127 +205,69 @@ func (app *application) produceKeystroke(event *midiQueueEvent) {
 			app.keyStatus.pressedKeys[keybind.VirtualKeyCode].LastChange = now
 			app.keyStatus.pressedKeys[keybind.VirtualKeyCode].LastRelease = now
 			app.keyStatus.pressedKeysCount--
+			app.logKeystroke("release", event, note, keybind, 0, "re-strike")
 		}
-		if app.keyStatus.ctrl.Pressed != keybind.Ctrl {
-			dwFlags := user32.KEYEVENTF_SCANCODE | user32.KEYEVENTF_KEYUP
-			if keybind.Ctrl {
-				dwFlags = user32.KEYEVENTF_SCANCODE
-			}
-			pInputs = append(pInputs, user32.INPUT_KEYBDINPUT{
-				Type: user32.INPUT_KEYBOARD,
-				Ki: user32.KEYBDINPUT{
-					WVk:         0,
-					WScan:       uint16(user32.MapVirtualKey(uint32(user32.VK_CONTROL), user32.MAPVK_VK_TO_VSC)),
-					DwFlags:     dwFlags,
-					Time:        0,
-					DwExtraInfo: 0,
-				},
-			})
-			if keybind.Ctrl {
-				app.keyStatus.ctrl.Pressed = true
-				app.keyStatus.ctrl.LastChange = now
-				app.keyStatus.ctrl.LastPress = now
-			} else {
-				app.keyStatus.ctrl.Pressed = false
-				app.keyStatus.ctrl.LastChange = now
-				app.keyStatus.ctrl.LastRelease = now
-			}
-			app.keyStatus.lastModifierTime = now
-		}
-		if app.keyStatus.alt.Pressed != keybind.Alt {
-			dwFlags := user32.KEYEVENTF_SCANCODE | user32.KEYEVENTF_KEYUP
-			if keybind.Alt {
-				dwFlags = user32.KEYEVENTF_SCANCODE
-			}
-			pInputs = append(pInputs, user32.INPUT_KEYBDINPUT{
-				Type: user32.INPUT_KEYBOARD,
-				Ki: user32.KEYBDINPUT{
-					WVk:         0,
-					WScan:       uint16(user32.MapVirtualKey(uint32(user32.VK_MENU), user32.MAPVK_VK_TO_VSC)),
-					DwFlags:     dwFlags,
-					Time:        0,
-					DwExtraInfo: 0,
-				},
-			})
-			if keybind.Alt {
-				app.keyStatus.alt.Pressed = true
-				app.keyStatus.alt.LastChange = now
-				app.keyStatus.alt.LastPress = now
-			} else {
-				app.keyStatus.alt.Pressed = false
-				app.keyStatus.alt.LastChange = now
-				app.keyStatus.alt.LastRelease = now
-			}
-			app.keyStatus.lastModifierTime = now
-		}
-		if app.keyStatus.shift.Pressed != keybind.Shift {
-			dwFlags := user32.KEYEVENTF_SCANCODE | user32.KEYEVENTF_KEYUP
-			if keybind.Shift {
-				dwFlags = user32.KEYEVENTF_SCANCODE
-			}
-			pInputs = append(pInputs, user32.INPUT_KEYBDINPUT{
-				Type: user32.INPUT_KEYBOARD,
-				Ki: user32.KEYBDINPUT{
-					WVk:         0,
-					WScan:       uint16(user32.MapVirtualKey(uint32(user32.VK_SHIFT), user32.MAPVK_VK_TO_VSC)),
-					DwFlags:     dwFlags,
-					Time:        0,
-					DwExtraInfo: 0,
-				},
-			})
-			if keybind.Shift {
-				app.keyStatus.shift.Pressed = true
-				app.keyStatus.shift.LastChange = now
-				app.keyStatus.shift.LastPress = now
-			} else {
-				app.keyStatus.shift.Pressed = false
-				app.keyStatus.shift.LastChange = now
-				app.keyStatus.shift.LastRelease = now
-			}
-			app.keyStatus.lastModifierTime = now
+		if app.octaveShiftEnabled() && len(pInputs) != 0 {
+			// changeOctave sends the taps itself, after the key is released
+			app.sendKeystrokes(pInputs)
+			pInputs = []user32.INPUT_KEYBDINPUT{}
 		}
-		if !event.Realtime && app.ModifierCooldown != 0 {
+		var modifierInputs []user32.INPUT_KEYBDINPUT
+		modifierInputs, now = app.changeModifiers(keybind, now)
+		pInputs = append(pInputs, modifierInputs...)
+		modifierCooldown := app.modifierCooldown()
+		if !event.Realtime && modifierCooldown != 0 {
 			if len(pInputs) != 0 {
-				_, err := user32.SendInput(pInputs)
-				if err != nil {
-					log.Println("Error: ", err)
-				}
-				app.printPressedKeys()
+				app.sendKeystrokes(pInputs)
 				pInputs = []user32.INPUT_KEYBDINPUT{}
-			}
-			waitTime := app.ModifierCooldown
-			if waitTime != 0 {
+				waitTime := modifierCooldown
 				log.Printf("Modifier cooldown (playback) %s.\n", waitTime)
-				time.Sleep(waitTime)
+				app.logKeystroke("wait", event, note, keybind, waitTime, "modifier cooldown")
+				app.clock.Sleep(waitTime)
+				now = now.Add(waitTime)
+			} else if !app.keyStatus.lastModifierTime.IsZero() && now.Sub(app.keyStatus.lastModifierTime) < modifierCooldown {
+				// Modifiers were prepared ahead of time, but not early enough
+				waitTime := app.keyStatus.lastModifierTime.Add(modifierCooldown).Sub(now)
+				log.Printf("Modifier cooldown (prepared) %s.\n", waitTime)
+				app.logKeystroke("wait", event, note, keybind, waitTime, "modifier cooldown, prepared too late")
+				app.clock.Sleep(waitTime)
 				now = now.Add(waitTime)
 			}
 		}
 		if !app.keyStatus.lastNoteTime.IsZero() && ((event.Message[0] == 0x80 && app.keyStatus.lastNote == uint8(note)) || event.Message[0] == 0x90) && now.Sub(app.keyStatus.lastNoteTime) < app.SkillCooldown {
 			waitTime := app.keyStatus.lastNoteTime.Add(app.SkillCooldown).Sub(now)
 			log.Printf("Skill cooldown sleep %s.\n", waitTime)
-			time.Sleep(waitTime)
+			app.logKeystroke("wait", event, note, keybind, waitTime, "skill cooldown")
+			app.clock.Sleep(waitTime)
 			now = now.Add(waitTime)
+			if !event.Realtime {
+				app.keyStatus.playbackCounters.Delayed++
+			}
 		}
 		if !event.Expiry.IsZero() && now.After(event.Expiry) {
+			app.logKeystroke("drop", event, note, keybind, 0, fmt.Sprintf("expired %s ago", now.Sub(event.Expiry)))
+			if !event.Realtime {
+				app.keyStatus.playbackCounters.Dropped++
+			}
 			return
 		}
+		if !event.Realtime {
+			app.keyStatus.playbackCounters.Sent++
+		}
 		if event.Realtime {
-			app.midiOutQueue.AddAction(event, now)
+			app.queueMidiOut(event, now)
 		} else {
-			app.midiOutQueue.AddAction(event, now.Add(app.PlaybackExtraDelay))
+			app.queueMidiOut(event, now.Add(app.PlaybackExtraDelay))
 		}
-		if event.Realtime && !app.keyStatus.lastModifierTime.IsZero() && now.Sub(app.keyStatus.lastModifierTime) < app.ModifierCooldown {
+		if event.Realtime && !app.keyStatus.lastModifierTime.IsZero() && now.Sub(app.keyStatus.lastModifierTime) < modifierCooldown {
 			if len(pInputs) != 0 {
-				_, err := user32.SendInput(pInputs)
-				if err != nil {
-					log.Println("Error: ", err)
-				}
-				app.printPressedKeys()
+				app.sendKeystrokes(pInputs)
 				pInputs = []user32.INPUT_KEYBDINPUT{}
 			}
-			waitTime := app.keyStatus.lastModifierTime.Add(app.ModifierCooldown).Sub(now)
+			waitTime := app.keyStatus.lastModifierTime.Add(modifierCooldown).Sub(now)
 			log.Printf("Modifier cooldown (realtime) %s.\n", waitTime)
-			time.Sleep(waitTime)
+			app.logKeystroke("wait", event, note, keybind, waitTime, "modifier cooldown")
+			app.clock.Sleep(waitTime)
 			now = now.Add(waitTime)
 		}
 		app.keyStatus.lastNote = uint8(note)
@@ -285,47 +287,198 @@ func (app *application) produceKeystroke(event *midiQueueEvent) {
 		app.keyStatus.pressedKeys[keybind.VirtualKeyCode].LastChange = now
 		app.keyStatus.pressedKeys[keybind.VirtualKeyCode].LastPress = now
 		app.keyStatus.pressedKeysCount++
+		app.keyStatus.rateLimiter.take(now, app.KeyRateLimit, app.KeyRateBurst)
+		app.logKeystroke("press", event, note, keybind, 0, "")
+		if app.keyStatus.pressedKeysCount == 1 && app.MaxKeyHoldDuration != 0 {
+			app.keyStatus.stuckKeyTimer.Reset(app.MaxKeyHoldDuration)
+		}
 	} else if event.Message[0] == 0xb0 {
 		if event.Realtime {
-			app.midiOutQueue.AddAction(event, now)
+			app.queueMidiOut(event, now)
 		} else {
-			app.midiOutQueue.AddAction(event, now.Add(app.PlaybackExtraDelay))
+			app.queueMidiOut(event, now.Add(app.PlaybackExtraDelay))
 		}
 		if len(event.Message) > 1 && event.Message[1] == 0x7b {
-			for i := 0; i < 256; i++ {
-				if app.keyStatus.pressedKeys[i].Pressed {
-					pInputs = append(pInputs, user32.INPUT_KEYBDINPUT{
-						Type: user32.INPUT_KEYBOARD,
-						Ki: user32.KEYBDINPUT{
-							WVk:         0,
-							WScan:       uint16(user32.MapVirtualKey(uint32(i), user32.MAPVK_VK_TO_VSC)),
-							DwFlags:     user32.KEYEVENTF_SCANCODE | user32.KEYEVENTF_KEYUP,
-							Time:        0,
-							DwExtraInfo: 0,
-						},
-					})
-					app.keyStatus.pressedKeys[i].Pressed = false
-					app.keyStatus.pressedKeys[i].LastChange = now
-					app.keyStatus.pressedKeys[i].LastRelease = now
-					app.keyStatus.pressedKeysCount--
-				}
-			}
+			pInputs = app.releaseAllKeys(now)
 			app.keyStatus.clearModifiersTimer.Reset(0)
+			app.logKeystroke("release", event, -1, nil, 0, "all notes off")
 		}
 	} else {
 		if event.Realtime {
-			app.midiOutQueue.AddAction(event, now)
+			app.queueMidiOut(event, now)
+		} else {
+			app.queueMidiOut(event, now.Add(app.PlaybackExtraDelay))
+		}
+	}
+	if len(pInputs) != 0 {
+		app.sendKeystrokes(pInputs)
+	}
+}
+
+// dropExpiredKeystroke drops an event that expired while it was waiting in the
+// keystroke queue, for example behind a long skill cooldown.
+func (app *application) dropExpiredKeystroke(event *midiQueueEvent, now time.Time) {
+	if event.PrepareModifiers {
+		return
+	}
+	note := int(event.Message[1])
+	if event.AlreadyTransposed {
+		note -= app.MidiOutTranspose
+		if note < 0x00 || note > 0x7f {
+			note = -1
+		}
+	}
+	app.logKeystroke("drop", event, note, nil, 0, fmt.Sprintf("expired %s ago in queue", now.Sub(event.Expiry)))
+	if !event.Realtime && event.Message[0] == 0x90 {
+		app.keyStatus.playbackCounters.Dropped++
+	}
+}
+
+// prepareModifiers presses the modifiers for an upcoming note of MIDI file
+// playback, so that the note key itself does not need to wait for the
+// modifier cooldown. If a key is still held, the change is postponed until it
+// is released, so the sounding note is not disturbed.
+func (app *application) prepareModifiers(event *midiQueueEvent, now time.Time) {
+	note := int(event.Message[1])
+	if event.AlreadyTransposed {
+		note -= app.MidiOutTranspose
+		if note < 0x00 || note > 0x7f {
+			return
+		}
+	}
+	keybind := app.chooseKeybinding(note, app.currentModifiers(), &app.keyStatus.pressedKeys)
+	if keybind == nil {
+		return
+	}
+	if app.keyStatus.pressedKeysCount != 0 {
+		app.keyStatus.pendingModifiers = keybind
+		app.keyStatus.pendingExpiry = event.Expiry
+		app.logKeystroke("prepare", event, note, keybind, 0, "postponed until keys are released")
+		return
+	}
+	app.logKeystroke("prepare", event, note, keybind, 0, "")
+	app.keyStatus.clearModifiersTimer.Reset(app.IdleDuration)
+	pInputs, _ := app.changeModifiers(keybind, now)
+	if len(pInputs) != 0 {
+		app.sendKeystrokes(pInputs)
+	}
+}
+
+// changeModifiers returns the input that brings the modifiers, or the octave
+// of the game in octave shift mode, to the state required by keybind.
+func (app *application) changeModifiers(keybind *keybindingPreset, now time.Time) ([]user32.INPUT_KEYBDINPUT, time.Time) {
+	if app.octaveShiftEnabled() {
+		return app.changeOctave(keybind, now)
+	}
+	pInputs := []user32.INPUT_KEYBDINPUT{}
+	if app.keyStatus.ctrl.Pressed != keybind.Ctrl {
+		dwFlags := user32.KEYEVENTF_SCANCODE | user32.KEYEVENTF_KEYUP
+		if keybind.Ctrl {
+			dwFlags = user32.KEYEVENTF_SCANCODE
+		}
+		pInputs = append(pInputs, user32.INPUT_KEYBDINPUT{
+			Type: user32.INPUT_KEYBOARD,
+			Ki: user32.KEYBDINPUT{
+				WVk:         0,
+				WScan:       uint16(user32.MapVirtualKey(uint32(user32.VK_CONTROL), user32.MAPVK_VK_TO_VSC)),
+				DwFlags:     dwFlags,
+				Time:        0,
+				DwExtraInfo: 0,
+			},
+		})
+		if keybind.Ctrl {
+			app.keyStatus.ctrl.Pressed = true
+			app.keyStatus.ctrl.LastChange = now
+			app.keyStatus.ctrl.LastPress = now
+		} else {
+			app.keyStatus.ctrl.Pressed = false
+			app.keyStatus.ctrl.LastChange = now
+			app.keyStatus.ctrl.LastRelease = now
+		}
+		app.keyStatus.lastModifierTime = now
+	}
+	if app.keyStatus.alt.Pressed != keybind.Alt {
+		dwFlags := user32.KEYEVENTF_SCANCODE | user32.KEYEVENTF_KEYUP
+		if keybind.Alt {
+			dwFlags = user32.KEYEVENTF_SCANCODE
+		}
+		pInputs = append(pInputs, user32.INPUT_KEYBDINPUT{
+			Type: user32.INPUT_KEYBOARD,
+			Ki: user32.KEYBDINPUT{
+				WVk:         0,
+				WScan:       uint16(user32.MapVirtualKey(uint32(user32.VK_MENU), user32.MAPVK_VK_TO_VSC)),
+				DwFlags:     dwFlags,
+				Time:        0,
+				DwExtraInfo: 0,
+			},
+		})
+		if keybind.Alt {
+			app.keyStatus.alt.Pressed = true
+			app.keyStatus.alt.LastChange = now
+			app.keyStatus.alt.LastPress = now
+		} else {
+			app.keyStatus.alt.Pressed = false
+			app.keyStatus.alt.LastChange = now
+			app.keyStatus.alt.LastRelease = now
+		}
+		app.keyStatus.lastModifierTime = now
+	}
+	if app.keyStatus.shift.Pressed != keybind.Shift {
+		dwFlags := user32.KEYEVENTF_SCANCODE | user32.KEYEVENTF_KEYUP
+		if keybind.Shift {
+			dwFlags = user32.KEYEVENTF_SCANCODE
+		}
+		pInputs = append(pInputs, user32.INPUT_KEYBDINPUT{
+			Type: user32.INPUT_KEYBOARD,
+			Ki: user32.KEYBDINPUT{
+				WVk:         0,
+				WScan:       uint16(user32.MapVirtualKey(uint32(user32.VK_SHIFT), user32.MAPVK_VK_TO_VSC)),
+				DwFlags:     dwFlags,
+				Time:        0,
+				DwExtraInfo: 0,
+			},
+		})
+		if keybind.Shift {
+			app.keyStatus.shift.Pressed = true
+			app.keyStatus.shift.LastChange = now
+			app.keyStatus.shift.LastPress = now
 		} else {
-			app.midiOutQueue.AddAction(event, now.Add(app.PlaybackExtraDelay))
+			app.keyStatus.shift.Pressed = false
+			app.keyStatus.shift.LastChange = now
+			app.keyStatus.shift.LastRelease = now
 		}
+		app.keyStatus.lastModifierTime = now
 	}
 	if len(pInputs) != 0 {
-		_, err := user32.SendInput(pInputs)
-		if err != nil {
-			log.Println("Error: ", err)
+		app.logKeystroke("modifiers", nil, -1, keybind, 0, "")
+	}
+	return pInputs, now
+}
+
+// releaseAllKeys returns the input that releases every held note key, and
+// forgets the modifiers prepared for the next note.
+func (app *application) releaseAllKeys(now time.Time) []user32.INPUT_KEYBDINPUT {
+	pInputs := []user32.INPUT_KEYBDINPUT{}
+	for i := 0; i < 256; i++ {
+		if app.keyStatus.pressedKeys[i].Pressed {
+			pInputs = append(pInputs, user32.INPUT_KEYBDINPUT{
+				Type: user32.INPUT_KEYBOARD,
+				Ki: user32.KEYBDINPUT{
+					WVk:         0,
+					WScan:       uint16(user32.MapVirtualKey(uint32(i), user32.MAPVK_VK_TO_VSC)),
+					DwFlags:     user32.KEYEVENTF_SCANCODE | user32.KEYEVENTF_KEYUP,
+					Time:        0,
+					DwExtraInfo: 0,
+				},
+			})
+			app.keyStatus.pressedKeys[i].Pressed = false
+			app.keyStatus.pressedKeys[i].LastChange = now
+			app.keyStatus.pressedKeys[i].LastRelease = now
+			app.keyStatus.pressedKeysCount--
 		}
-		app.printPressedKeys()
 	}
+	app.keyStatus.pendingModifiers = nil
+	return pInputs
 }
 
 func (app *application) clearModifiers(now time.Time) {
@@ -379,17 +532,33 @@ func (app *application) clearModifiers(now time.Time) {
 		app.keyStatus.lastModifierTime = now
 	}
 	if len(pInputs) != 0 {
-		_, err := user32.SendInput(pInputs)
-		if err != nil {
-			log.Println("Error: ", err)
-		}
-		app.printPressedKeys()
+		app.logKeystroke("modifiers", nil, -1, &keybindingPreset{}, 0, "idle")
+		app.sendKeystrokes(pInputs)
+	}
+}
+
+func (app *application) sendKeystrokes(pInputs []user32.INPUT_KEYBDINPUT) {
+	if app.simulation != nil {
+		app.simulation.recordKeystrokes(app.clock.Now(), app.pressedKeysLine())
+		return
 	}
+	_, err := user32.SendInput(pInputs)
+	if err != nil {
+		log.Println("Error: ", err)
+	}
+	app.printPressedKeys()
 }
 
 func (app *application) printPressedKeys() {
+	log.Println(app.pressedKeysLine())
+}
+
+func (app *application) pressedKeysLine() string {
 	pressedKeysCount := 0
 	line := "["
+	if app.octaveShiftEnabled() {
+		line += fmt.Sprintf(" Octave%+d", app.keyStatus.octave)
+	}
 	if app.keyStatus.ctrl.Pressed {
 		line += " Ctrl"
 	}
@@ -406,8 +575,8 @@ func (app *application) printPressedKeys() {
 		}
 	}
 	line += " ]"
-	log.Println(line)
 	if pressedKeysCount != app.keyStatus.pressedKeysCount {
 		panic(fmt.Sprintf("pressedKeysCount (%d) != keyStatus.pressedKeysCount (%d)", pressedKeysCount, app.keyStatus.pressedKeysCount))
 	}
+	return line
 }
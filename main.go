@@ -55,17 +55,24 @@ type application struct {
 	MidiPlaybackGoro cgc.Executor
 	KeystrokeGoro    cgc.Executor
 
-	MidiInDevice                int
+	MidiInputs                  []*midiInput
 	MidiOutDevice               int
+	MidiOutDeviceName           string
 	MidiOutBank                 uint16
 	MidiOutPatch                uint8
 	MidiOutTranspose            int
+	MidiOutClock                bool
 	MidiPlaybackTrack           uint16
 	MidiPlaybackOffset          time.Duration
 	MidiPlaybackSchedule        time.Time
 	MidiPlaybackScheduleEnabled bool
 	MidiPlaybackLoop            time.Duration
 	MidiPlaybackLoopEnabled     bool
+	MidiPlaybackPaused          bool
+	MidiPlaybackCountIn         int
+	MidiPlaybackChase           string
+	MidiPlaybackSync            string
+	KeybindingProfile           string
 	NtpSyncServer               string
 	NtpLastSync                 time.Time
 	NtpClockOffset              time.Duration
@@ -73,19 +80,32 @@ type application struct {
 
 	ctx context.Context
 
-	hWnd        uintptr
-	hMidiIn     uintptr
-	hMidiOut    uintptr
-	sysexBuffer [2]*winmm.MIDIHDR
+	hWnd     uintptr
+	hMidiOut uintptr
+
+	rtpMidiSession  *rtpMidiSession
+	midiLearnAction string
+	midiOutError    string
 
 	midiOutQueue   *actionqueue.Queue
 	keystrokeQueue *actionqueue.Queue
 
-	keyStatus *keystrokeStatus
+	keyStatus    *keystrokeStatus
+	keysReleased chan struct{}
+	keystrokeLog *keystrokeLog
+	externalSync *externalSync
+	arpeggiator  *arpeggiator
+
+	clock      clock
+	simulation *simulation
 
 	midiFileBuffer *midiFileBuffer
+	calendar       *calendar
 
 	ntpMutex *sync.RWMutex
+
+	keybindingMutex   sync.RWMutex
+	activeKeybindings *keybindingProfile
 }
 
 func main() {
@@ -94,6 +114,10 @@ func main() {
 }
 
 func (app *application) run(args []string) int {
+	if len(args) > 1 && args[1] == "simulate" {
+		return app.runSimulation(args[2:])
+	}
+
 	runtime.LockOSThread()
 	_ = kernel32.SetPriorityClass(kernel32.GetCurrentProcess(), kernel32.HIGH_PRIORITY_CLASS)
 
@@ -112,6 +136,8 @@ func (app *application) run(args []string) int {
 		return app.delayReturn(1)
 	}
 
+	app.clock = realClock{}
+
 	app.ctx, app.Quit = context.WithCancel(context.Background())
 
 	app.KeystrokeGoro = cgc.NewBuffered(1)
@@ -119,12 +145,14 @@ func (app *application) run(args []string) int {
 	app.NtpGoro = cgc.NewBuffered(1)
 	app.MidiPlaybackGoro = cgc.NewBuffered(1)
 
-	app.MidiInDevice = -1
 	app.MidiOutDevice = -1
 	app.MidiOutBank = 0
 	app.MidiOutPatch = 46
 	app.MidiOutTranspose = 0
 	app.MidiPlaybackTrack = 1
+	app.MidiPlaybackChase = "plucked"
+	app.MidiPlaybackSync = "off"
+	app.KeybindingProfile = defaultKeybindingProfile
 
 	app.midiOutQueue = actionqueue.New()
 	app.midiOutQueue.Run(app.ctx)
@@ -132,6 +160,9 @@ func (app *application) run(args []string) int {
 	app.keystrokeQueue.Run(app.ctx)
 
 	app.ntpMutex = new(sync.RWMutex)
+	app.keystrokeLog = newKeystrokeLog()
+	app.externalSync = newExternalSync(app.clock)
+	app.arpeggiator = newArpeggiator(app.clock)
 
 	err = app.startWebServer()
 	if err != nil {
@@ -139,6 +170,11 @@ func (app *application) run(args []string) int {
 		return app.delayReturn(1)
 	}
 
+	err = app.startOscServer()
+	if err != nil {
+		log.Println("Error: ", err)
+	}
+
 	hWndClass, err := user32.RegisterClassEx(0, app.windowProc, 0, 0, 0, 0, 0, 0, 0, "midi2ffxiv", 0)
 	if err != nil {
 		log.Println("Error: ", err)
@@ -167,6 +203,9 @@ func (app *application) run(args []string) int {
 		}
 	}
 
+	app.keysReleased = make(chan struct{})
+	defer app.releaseKeysOnPanic()
+
 	go app.consumeStdin()
 	go app.processKeystrokes()
 	go app.processMidiPlayback()
@@ -174,10 +213,17 @@ func (app *application) run(args []string) int {
 	go app.processNTP()
 	go app.waitForQuit()
 
+	err = kernel32.SetConsoleCtrlHandler(app.onConsoleCtrl, true)
+	if err != nil {
+		log.Println("Error: ", err)
+	}
+
 	for {
 		bResult, lpMsg, err := user32.GetMessage(app.hWnd, 0, 0)
 		if err != nil {
 			log.Println("Error: ", err)
+			app.Quit()
+			app.waitForKeysReleased()
 			os.Exit(int(err.(syscall.Errno)))
 		}
 		if bResult == 0 {
@@ -188,6 +234,7 @@ func (app *application) run(args []string) int {
 	}
 
 	app.Quit()
+	app.waitForKeysReleased()
 
 	return 0
 }
@@ -253,19 +300,21 @@ func (app *application) windowProc(hWnd uintptr, uMsg uint32, wParam, lParam uin
 		// no-op
 	case winmm.MM_MIM_DATA, winmm.MM_MIM_MOREDATA:
 		midiEvent := []byte{byte(lParam), byte(lParam >> 8), byte(lParam >> 16)}
+		hMidiIn := wParam
 		app.MidiRealtimeGoro.SubmitNoWait(app.ctx, func(context.Context) (interface{}, error) {
-			app.onMidiInEvent(midiEvent)
+			app.onMidiInEvent(app.findMidiInput(hMidiIn).filter(midiEvent))
 			return nil, nil
 		})
 	case winmm.MM_MIM_LONGDATA:
 		midiHeader := (*winmm.MIDIHDR)(unsafe.Pointer(lParam))
 		midiEvent := make([]byte, midiHeader.DwBytesRecorded)
 		copy(midiEvent, (*[65536]byte)(unsafe.Pointer(midiHeader.LpData))[:midiHeader.DwBytesRecorded])
+		hMidiIn := wParam
 		app.MidiRealtimeGoro.Submit(app.ctx, func(context.Context) (interface{}, error) {
-			app.onMidiInEvent(midiEvent)
+			app.onMidiInEvent(app.findMidiInput(hMidiIn).filter(midiEvent))
 			return nil, nil
 		})
-		err := winmm.MidiInAddBuffer(app.hMidiIn, midiHeader)
+		err := winmm.MidiInAddBuffer(hMidiIn, midiHeader)
 		if err != nil {
 			log.Println("Error: ", err)
 		}
@@ -277,7 +326,7 @@ func (app *application) windowProc(hWnd uintptr, uMsg uint32, wParam, lParam uin
 		midiEvent := make([]byte, midiHeader.DwBytesRecorded)
 		copy(midiEvent, (*[65536]byte)(unsafe.Pointer(midiHeader.LpData))[:midiHeader.DwBytesRecorded])
 		log.Printf("Invalid MIDI message: %x\n", midiEvent)
-		err := winmm.MidiInAddBuffer(app.hMidiIn, midiHeader)
+		err := winmm.MidiInAddBuffer(wParam, midiHeader)
 		if err != nil {
 			log.Println("Error: ", err)
 		}
@@ -0,0 +1,148 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"time"
+)
+
+const (
+	// clockOutputLookahead is how far ahead clock messages are queued to the
+	// echo synth, and clockOutputInterval how often more are queued.
+	clockOutputLookahead = 100 * time.Millisecond
+	clockOutputInterval  = 50 * time.Millisecond
+)
+
+func (app *application) setMidiOutClock(enabled bool) {
+	app.MidiOutClock = enabled
+	app.midiFileBuffer.clockOutTimer.Reset(0)
+}
+
+// runMidiClockOutput sends MIDI clock, Song Position Pointer and start/stop
+// to the echo synth during MIDI file playback, delayed like the notes, so that
+// a DAW or drum machine can play along with the performance.
+func (app *application) runMidiClockOutput(now time.Time) {
+	buffer := app.midiFileBuffer
+	playbackProgress := now.Add(app.NtpClockOffset).Add(app.MidiPlaybackOffset).Sub(app.MidiPlaybackSchedule)
+	if app.MidiPlaybackLoopEnabled && app.MidiPlaybackLoop > 0 && playbackProgress > 0 {
+		playbackProgress %= app.MidiPlaybackLoop
+	}
+	if !app.midiClockOutputActive(playbackProgress) {
+		app.stopMidiClockOutput(now)
+		return
+	}
+	// Looping back to the beginning, the sequencer is moved with Song Position
+	// Pointer and Continue instead of Stop, which would be sent after them
+	looped := false
+	if buffer.clockOutRunning && app.clocksToProgress(buffer.clockOutNext) > playbackProgress+clockOutputLookahead+clockOutputInterval {
+		buffer.clockOutRunning = false
+		looped = true
+	}
+	songStart := now.Add(-playbackProgress).Add(app.PlaybackExtraDelay)
+	horizon := playbackProgress + clockOutputLookahead
+	// No clock is queued past the loop end, since queued messages can't be
+	// taken back after the loop restarts
+	if app.MidiPlaybackLoopEnabled && app.MidiPlaybackLoop > 0 && horizon > app.MidiPlaybackLoop-time.Millisecond {
+		horizon = app.MidiPlaybackLoop - time.Millisecond
+	}
+	if !buffer.clockOutRunning {
+		if horizon < 0 {
+			buffer.clockOutTimer.Reset(-horizon)
+			return
+		}
+		// Start or continue at the next sixteenth note
+		sixteenth := int64(0)
+		if playbackProgress > 0 {
+			sixteenth = (app.progressToClocks(playbackProgress) + 5) / 6
+		}
+		buffer.clockOutNext = sixteenth * 6
+		startTime := songStart.Add(app.clocksToProgress(buffer.clockOutNext)).Add(-time.Millisecond)
+		if sixteenth == 0 && !looped {
+			app.queueMidiClockOutput([]byte{0xfa}, startTime)
+		} else {
+			app.queueMidiClockOutput([]byte{0xf2, uint8(sixteenth) & 0x7f, uint8(sixteenth>>7) & 0x7f}, startTime)
+			app.queueMidiClockOutput([]byte{0xfb}, startTime)
+		}
+		buffer.clockOutRunning = true
+	}
+	for {
+		progress := app.clocksToProgress(buffer.clockOutNext)
+		if progress >= horizon {
+			break
+		}
+		app.queueMidiClockOutput([]byte{0xf8}, songStart.Add(progress))
+		buffer.clockOutNext++
+	}
+	buffer.clockOutTimer.Reset(clockOutputInterval)
+}
+
+func (app *application) midiClockOutputActive(playbackProgress time.Duration) bool {
+	if !app.MidiOutClock || app.MidiPlaybackSync != "off" || !app.MidiPlaybackScheduleEnabled || app.MidiPlaybackPaused || app.midiFileBuffer.TicksPerBeat == 0 {
+		return false
+	}
+	track := app.MidiPlaybackTrack
+	if len(app.midiFileBuffer.MidiTracks) == 1 {
+		track = 0
+	}
+	if int(track) >= len(app.midiFileBuffer.MidiTracks) {
+		return false
+	}
+	thisTrack := app.midiFileBuffer.MidiTracks[track]
+	return len(thisTrack) != 0 && playbackProgress <= thisTrack[len(thisTrack)-1].Microseconds.Duration()
+}
+
+func (app *application) stopMidiClockOutput(now time.Time) {
+	if !app.midiFileBuffer.clockOutRunning {
+		return
+	}
+	app.midiFileBuffer.clockOutRunning = false
+	app.queueMidiClockOutput([]byte{0xfc}, now.Add(app.PlaybackExtraDelay))
+}
+
+func (app *application) queueMidiClockOutput(message []byte, t time.Time) {
+	app.queueMidiOut(&midiQueueEvent{
+		Time:    t,
+		Message: message,
+	}, t)
+}
+
+// progressToClocks converts a position in the song to MIDI clocks, 24 per
+// quarter note, following its tempo map.
+func (app *application) progressToClocks(playbackProgress time.Duration) int64 {
+	ticksPerBeat := int64(app.midiFileBuffer.TicksPerBeat)
+	ticks := int64(0)
+	elapsed := time.Duration(0)
+	msPerBeat := uint32(500000)
+	for _, entry := range app.midiFileBuffer.TempoTable {
+		entryTime := elapsed + time.Duration(entry.TicksElapsed-ticks)*time.Duration(msPerBeat)*time.Microsecond/time.Duration(ticksPerBeat)
+		if entryTime > playbackProgress {
+			break
+		}
+		ticks, elapsed, msPerBeat = entry.TicksElapsed, entryTime, entry.MicrosecondsPerBeat
+	}
+	ticks += int64((playbackProgress - elapsed) * time.Duration(ticksPerBeat) / (time.Duration(msPerBeat) * time.Microsecond))
+	return ticks * 24 / ticksPerBeat
+}
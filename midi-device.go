@@ -0,0 +1,162 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// midiDevicePollInterval is how often the device lists are checked, so that
+// a device which is plugged in again is reopened.
+const midiDevicePollInterval = 2 * time.Second
+
+func normalizeDeviceName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+func findDeviceName(devices []string, name string) int {
+	for i, device := range devices {
+		if normalizeDeviceName(device) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// restoreMidiDevices opens the devices saved in the config file.
+func (app *application) restoreMidiDevices() {
+	for i := range app.MidiInputDevices {
+		input := app.MidiInputDevices[i]
+		input.Device = -1
+		app.MidiInputs = append(app.MidiInputs, &input)
+	}
+	app.MidiOutDeviceName = app.MidiOutputDevice
+	app.pollMidiDevices()
+}
+
+// pollMidiDevices closes the devices that were unplugged, and opens the ones
+// that appeared, since winmm indexes change when devices are plugged in.
+func (app *application) pollMidiDevices() {
+	inDevices := app.listMidiInDevices()
+	for _, input := range app.MidiInputs {
+		index := findDeviceName(inDevices, input.Name)
+		if input.connected() {
+			if input.network && index >= 0 {
+				input.Device = index
+				continue
+			}
+			if input.Device < len(inDevices) && normalizeDeviceName(inDevices[input.Device]) == input.Name {
+				continue
+			}
+			log.Printf("MIDI input device %q disconnected.\n", input.Name)
+			app.closeMidiInDevice(input)
+			app.sendAllNoteOff(true)
+		}
+		if index < 0 {
+			continue
+		}
+		err := app.openMidiInDevice(input, index)
+		if err != nil {
+			if input.Error != err.Error() {
+				log.Printf("Failed to open MIDI input device %q: %s\n", input.Name, err)
+			}
+			input.Error = err.Error()
+			continue
+		}
+		input.Error = ""
+		log.Printf("MIDI input device %q connected.\n", input.Name)
+	}
+
+	if app.MidiOutDeviceName == "" {
+		return
+	}
+	outDevices := app.listMidiOutDevices()
+	if app.hMidiOut != 0 {
+		if app.MidiOutDevice < len(outDevices) && normalizeDeviceName(outDevices[app.MidiOutDevice]) == app.MidiOutDeviceName {
+			return
+		}
+		log.Printf("MIDI output device %q disconnected.\n", app.MidiOutDeviceName)
+		app.closeMidiOutDevice()
+	}
+	index := findDeviceName(outDevices, app.MidiOutDeviceName)
+	if index < 0 {
+		return
+	}
+	err := app.openMidiOutDevice(index)
+	if err != nil {
+		if app.midiOutError != err.Error() {
+			log.Printf("Failed to open MIDI output device %q: %s\n", app.MidiOutDeviceName, err)
+		}
+		app.midiOutError = err.Error()
+		return
+	}
+	app.midiOutError = ""
+	log.Printf("MIDI output device %q connected.\n", app.MidiOutDeviceName)
+}
+
+// setMidiOutDevice selects the output device by its index, and remembers it
+// by name. An index of -1 closes the device.
+func (app *application) setMidiOutDevice(midiOutDevice int) error {
+	if midiOutDevice < 0 {
+		app.closeMidiOutDevice()
+		app.MidiOutDeviceName = ""
+		app.midiOutError = ""
+		return app.saveMidiDevices()
+	}
+	err := app.openMidiOutDevice(midiOutDevice)
+	if err != nil {
+		return err
+	}
+	if outDevices := app.listMidiOutDevices(); midiOutDevice < len(outDevices) {
+		app.MidiOutDeviceName = normalizeDeviceName(outDevices[midiOutDevice])
+	}
+	app.midiOutError = ""
+	return app.saveMidiDevices()
+}
+
+// saveMidiDevices remembers the selected devices in the config file.
+func (app *application) saveMidiDevices() error {
+	inputLines := make([]string, len(app.MidiInputs))
+	for i, input := range app.MidiInputs {
+		channel := "all"
+		if input.Channel >= 0 {
+			channel = fmt.Sprint(input.Channel + 1)
+		}
+		inputLines[i] = fmt.Sprintf("MidiInputDevice         %-7s %-7d %s", channel, input.Transpose, input.Name)
+	}
+	err := app.saveConfigLines("MidiInputDevice", inputLines)
+	if err != nil {
+		return err
+	}
+	outputLines := []string{}
+	if app.MidiOutDeviceName != "" {
+		outputLines = append(outputLines, "MidiOutputDevice        "+app.MidiOutDeviceName)
+	}
+	return app.saveConfigLines("MidiOutputDevice", outputLines)
+}
@@ -0,0 +1,151 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// midiActionBinding triggers a control action by a message from the input
+// devices: a control change with a value of at least 64, a program change,
+// or a note on.
+type midiActionBinding struct {
+	Action  string `json:"action"`
+	Trigger string `json:"trigger"` // "cc", "program" or "note"
+	Channel int    `json:"channel"` // 1 to 16
+	Number  uint8  `json:"number"`
+}
+
+func validateMidiActionBindings(bindings []midiActionBinding) error {
+	for _, binding := range bindings {
+		if !isControlAction(binding.Action) {
+			return fmt.Errorf("unrecognized control action %q", binding.Action)
+		}
+		if binding.Trigger != "cc" && binding.Trigger != "program" && binding.Trigger != "note" {
+			return fmt.Errorf("trigger of action %q must be cc, program or note", binding.Action)
+		}
+		if binding.Channel < 1 || binding.Channel > 16 {
+			return fmt.Errorf("channel %d out of range in action %q", binding.Channel, binding.Action)
+		}
+		if binding.Number > 0x7f {
+			return fmt.Errorf("number %d out of range in action %q", binding.Number, binding.Action)
+		}
+	}
+	return nil
+}
+
+// midiActionTrigger returns the binding that a message would have, and
+// whether the message presses it.
+func midiActionTrigger(message []byte) (binding midiActionBinding, pressed bool, ok bool) {
+	if len(message) < 2 || message[0] < 0x80 || message[0] >= 0xf0 {
+		return
+	}
+	binding.Channel = int(message[0]&0x0f) + 1
+	binding.Number = message[1]
+	switch message[0] & 0xf0 {
+	case 0x80:
+		binding.Trigger = "note"
+	case 0x90:
+		binding.Trigger = "note"
+		pressed = len(message) >= 3 && message[2] != 0
+	case 0xb0:
+		binding.Trigger = "cc"
+		pressed = len(message) >= 3 && message[2] >= 0x40
+	case 0xc0:
+		binding.Trigger = "program"
+		pressed = true
+	default:
+		return
+	}
+	ok = true
+	return
+}
+
+// handleMidiAction learns or triggers a control action, and reports whether
+// the message is consumed.
+func (app *application) handleMidiAction(message []byte) bool {
+	trigger, pressed, ok := midiActionTrigger(message)
+	if !ok {
+		return false
+	}
+	if app.midiLearnAction != "" && pressed {
+		trigger.Action = app.midiLearnAction
+		app.midiLearnAction = ""
+		bindings := []midiActionBinding{}
+		for _, binding := range app.MidiActions {
+			if binding.Action != trigger.Action && (binding.Trigger != trigger.Trigger || binding.Channel != trigger.Channel || binding.Number != trigger.Number) {
+				bindings = append(bindings, binding)
+			}
+		}
+		log.Printf("Learned %s %d on channel %d for action %q.\n", trigger.Trigger, trigger.Number, trigger.Channel, trigger.Action)
+		err := app.setMidiActions(append(bindings, trigger))
+		if err != nil {
+			log.Println("Error: ", err)
+		}
+		return true
+	}
+	for _, binding := range app.MidiActions {
+		if binding.Trigger == trigger.Trigger && binding.Channel == trigger.Channel && binding.Number == trigger.Number {
+			if pressed {
+				_ = app.runControlAction(binding.Action)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (app *application) getMidiActions() []midiActionBinding {
+	return append([]midiActionBinding{}, app.MidiActions...)
+}
+
+func (app *application) setMidiActions(bindings []midiActionBinding) error {
+	err := validateMidiActionBindings(bindings)
+	if err != nil {
+		return err
+	}
+	app.MidiActions = bindings
+	return app.saveMidiActions()
+}
+
+// setMidiLearnAction makes the next message from the input devices trigger
+// the action. An empty action cancels learning.
+func (app *application) setMidiLearnAction(action string) error {
+	if action != "" && !isControlAction(action) {
+		return fmt.Errorf("unrecognized control action %q", action)
+	}
+	app.midiLearnAction = action
+	return nil
+}
+
+func (app *application) saveMidiActions() error {
+	lines := make([]string, len(app.MidiActions))
+	for i, binding := range app.MidiActions {
+		lines[i] = fmt.Sprintf("MidiAction              %-15s %-7s %-7d %d", binding.Action, binding.Trigger, binding.Channel, binding.Number)
+	}
+	return app.saveConfigLines("MidiAction", lines)
+}
@@ -26,10 +26,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"time"
 
@@ -40,12 +44,23 @@ import (
 )
 
 type midiFileBuffer struct {
-	MidiTracks     []midiFileTrack
-	TempoTable     []tempoEntry
-	TicksPerBeat   uint16
-	nextEventIndex int
-	nextEventTimer *time.Timer
-	fastForward    bool
+	SongHash           string
+	SongSettings       songSettings
+	OriginalTracks     []midiFileTrack
+	MidiTracks         []midiFileTrack
+	TempoTable         []tempoEntry
+	TimeSignatureTable []timeSignatureEntry
+	TicksPerBeat       uint16
+	nextEventIndex     int
+	nextPrepareIndex   int
+	nextEventTimer     clockTimer
+	fastForward        bool
+	pausedProgress     time.Duration
+	countInNextBeat    int
+	countInTimer       clockTimer
+	clockOutTimer      clockTimer
+	clockOutRunning    bool
+	clockOutNext       int64
 }
 
 type midiFileTrack []*midiFileEvent
@@ -61,15 +76,43 @@ type midiFileAbsoluteTime struct {
 	Denominator uint16 // = TicksPerBeat
 }
 
+type articulationProfile struct {
+	Restrike     bool
+	MaxAge       time.Duration
+	MinRemaining time.Duration
+}
+
+// When joining in the middle of a song, a note that is still sounding is
+// played again only if it makes sense for the instrument: plucked strings
+// decay quickly, while wind instruments sustain as long as the key is held.
+var articulationProfiles = map[string]articulationProfile{
+	"none":      {false, 0, 0},
+	"plucked":   {true, 500 * time.Millisecond, 0},
+	"sustained": {true, 0, 250 * time.Millisecond},
+}
+
 type tempoEntry struct {
 	TicksElapsed        int64
 	MicrosecondsPerBeat uint32
 }
 
+type timeSignatureEntry struct {
+	TicksElapsed int64
+	Numerator    uint8
+	Denominator  uint8
+}
+
 func (app *application) processMidiPlayback() {
+	defer app.releaseKeysOnPanic()
 	app.midiFileBuffer = &midiFileBuffer{
-		nextEventTimer: time.NewTimer(0),
+		nextEventTimer: app.clock.NewTimer(0),
+		countInTimer:   app.clock.NewTimer(0),
+		clockOutTimer:  app.clock.NewTimer(0),
+	}
+	app.calendar = &calendar{
+		timer: app.clock.NewTimer(0),
 	}
+	app.loadCalendar()
 	for {
 		select {
 		case r, ok := <-app.MidiPlaybackGoro:
@@ -77,8 +120,18 @@ func (app *application) processMidiPlayback() {
 				return
 			}
 			_ = cgc.RunOneRequest(app.ctx, r)
-		case now := <-app.midiFileBuffer.nextEventTimer.C:
+		case now := <-app.midiFileBuffer.nextEventTimer.Chan():
 			app.playNextMidiEvent(now)
+		case now := <-app.midiFileBuffer.countInTimer.Chan():
+			app.playNextCountInClick(now)
+		case now := <-app.midiFileBuffer.clockOutTimer.Chan():
+			app.runMidiClockOutput(now)
+		case now := <-app.calendar.timer.Chan():
+			app.runCalendar(now)
+		case event := <-app.externalSync.queue:
+			app.onExternalSync(event)
+		case <-app.externalSync.timeout.Chan():
+			app.onExternalSyncTimeout()
 		case <-app.ctx.Done():
 			return
 		}
@@ -87,12 +140,17 @@ func (app *application) processMidiPlayback() {
 
 func (app *application) setMidiPlaybackFile(midiFile io.Reader) error {
 	var err error
-	parsedFile, err := smfio.Read(midiFile)
+	data, err := ioutil.ReadAll(midiFile)
+	if err != nil {
+		return err
+	}
+	parsedFile, err := smfio.Read(bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
 	midiTracks := make([]midiFileTrack, parsedFile.GetTracksNum())
 	tempoTable := []tempoEntry{}
+	timeSignatureTable := []timeSignatureEntry{}
 	division := parsedFile.GetDivision()
 	if division.IsSMTPE() {
 		return errors.New("MIDI with SMTPE timestamps is unsupported")
@@ -100,6 +158,7 @@ func (app *application) setMidiPlaybackFile(midiFile io.Reader) error {
 	for trackID := range midiTracks {
 		if parsedFile.GetFormat() == smf.Format2 {
 			tempoTable = []tempoEntry{}
+			timeSignatureTable = []timeSignatureEntry{}
 		}
 		parsedTrack := parsedFile.GetTrack(uint16(trackID))
 		track := make([]*midiFileEvent, 0, parsedTrack.Len())
@@ -147,6 +206,19 @@ func (app *application) setMidiPlaybackFile(midiFile io.Reader) error {
 						MicrosecondsPerBeat: msPerBeat,
 					})
 				}
+				if len(message) > 2 && message[1] == smf.MetaTimeSignature {
+					// Only count-in and bar numbers need the time signature, which
+					// fall back to 4/4 if it is missing
+					if len(message) != 7 || message[2] != 4 || message[4] > 7 {
+						log.Println("Unrecognized MIDI time signature, ignored")
+					} else {
+						timeSignatureTable = append(timeSignatureTable, timeSignatureEntry{
+							TicksElapsed: ticks,
+							Numerator:    message[3],
+							Denominator:  1 << message[4],
+						})
+					}
+				}
 
 			case *smf.SysexEvent:
 				message = []byte{event.GetStatus()}
@@ -169,14 +241,19 @@ func (app *application) setMidiPlaybackFile(midiFile io.Reader) error {
 
 		midiTracks[trackID] = track
 	}
-	app.midiFileBuffer.MidiTracks = midiTracks
+	songHash := sha256.Sum256(data)
+	app.midiFileBuffer.SongHash = hex.EncodeToString(songHash[:])
+	app.midiFileBuffer.OriginalTracks = midiTracks
 	app.midiFileBuffer.TempoTable = tempoTable
+	app.midiFileBuffer.TimeSignatureTable = timeSignatureTable
 	app.midiFileBuffer.TicksPerBeat = division.GetTicks()
+	app.midiFileBuffer.SongSettings = app.loadSongSettings(app.midiFileBuffer.SongHash)
+	app.loadMidiTracks()
 	return nil
 }
 
 func (app *application) playNextMidiEvent(now time.Time) {
-	if !app.MidiPlaybackScheduleEnabled {
+	if !app.MidiPlaybackScheduleEnabled || app.MidiPlaybackPaused {
 		return
 	}
 	track := app.MidiPlaybackTrack
@@ -195,6 +272,7 @@ func (app *application) playNextMidiEvent(now time.Time) {
 			log.Println("Fast-forward off.")
 			app.midiFileBuffer.fastForward = false
 		}
+		app.prepareNextMidiNote(now, playbackProgress, app.midiFileBuffer.MidiTracks[track])
 		return
 	}
 	if app.MidiPlaybackLoopEnabled && app.MidiPlaybackLoop > 0 {
@@ -205,6 +283,7 @@ func (app *application) playNextMidiEvent(now time.Time) {
 	if index >= len(thisTrack) {
 		if app.MidiPlaybackLoopEnabled {
 			app.midiFileBuffer.nextEventIndex = 0
+			app.midiFileBuffer.nextPrepareIndex = 0
 			waitTime := app.MidiPlaybackLoop - playbackProgress
 			if waitTime < 0 {
 				waitTime = 0
@@ -221,6 +300,7 @@ func (app *application) playNextMidiEvent(now time.Time) {
 				app.sendAllNoteOff(false)
 				return nil, nil
 			})
+			app.advanceSetlist(now)
 		}
 		return
 	}
@@ -237,20 +317,192 @@ func (app *application) playNextMidiEvent(now time.Time) {
 		if app.midiFileBuffer.fastForward {
 			log.Println("Fast-forward off.")
 			app.midiFileBuffer.fastForward = false
+			app.chaseMidiPlayback(now, playbackProgress, thisTrack)
 		}
+		app.prepareNextMidiNote(now, playbackProgress, thisTrack)
 		return
 	}
 	app.addMidiEvent(&midiQueueEvent{
-		Time:              now.Add(-playbackProgress).Add(nextNoteProgress),
-		Message:           thisTrack[index].Message,
-		Realtime:          false,
-		FastForward:       app.midiFileBuffer.fastForward,
-		AlreadyTransposed: true,
+		Time:        now.Add(-playbackProgress).Add(nextNoteProgress),
+		Message:     thisTrack[index].Message,
+		Realtime:    false,
+		FastForward: app.midiFileBuffer.fastForward,
 	})
 	app.midiFileBuffer.nextEventIndex = index + 1
 	app.midiFileBuffer.nextEventTimer.Reset(0)
 }
 
+// prepareNextMidiNote tells the keystroke goroutine to press the modifiers, or
+// shift the octave, of the next note one cooldown ahead of it, so that the
+// note itself can be played on time.
+func (app *application) prepareNextMidiNote(now time.Time, playbackProgress time.Duration, thisTrack midiFileTrack) {
+	modifierCooldown := app.modifierCooldown()
+	if modifierCooldown == 0 || app.midiFileBuffer.nextPrepareIndex > app.midiFileBuffer.nextEventIndex {
+		return
+	}
+	for i := app.midiFileBuffer.nextEventIndex; i < len(thisTrack); i++ {
+		message := thisTrack[i].Message
+		if len(message) != 3 || message[0]&0xf0 != 0x90 || message[0]&0xf == 9 || message[2] == 0 || message[2] < app.MinTriggerVelocity {
+			continue
+		}
+		noteTime := now.Add(-playbackProgress).Add(thisTrack[i].Microseconds.Duration())
+		prepareTime := noteTime.Add(-modifierCooldown)
+		app.queueKeystrokeWithExpiry(&midiQueueEvent{
+			Time:             prepareTime,
+			Expiry:           noteTime,
+			Message:          []byte{0x90, message[1], message[2]},
+			Realtime:         false,
+			PrepareModifiers: true,
+		}, prepareTime, noteTime)
+		app.midiFileBuffer.nextPrepareIndex = i + 1
+		return
+	}
+	app.midiFileBuffer.nextPrepareIndex = len(thisTrack) + 1
+}
+
+// chaseMidiPlayback is called when playback joins a song at a position other
+// than the beginning, and re-strikes a note that is still sounding at that
+// position, according to the articulation profile. The game plays one note at
+// a time, so of the sounding notes the profile accepts, the one that started
+// last is played, and the highest one if several started together.
+func (app *application) chaseMidiPlayback(now time.Time, playbackProgress time.Duration, thisTrack midiFileTrack) {
+	profile := articulationProfiles[app.MidiPlaybackChase]
+	if !profile.Restrike {
+		return
+	}
+	index := app.midiFileBuffer.nextEventIndex
+	if index > len(thisTrack) {
+		index = len(thisTrack)
+	}
+	var soundingNotes [16][128]int
+	for i, event := range thisTrack[:index] {
+		if len(event.Message) != 3 {
+			continue
+		}
+		channel, note := event.Message[0]&0xf, event.Message[1]&0x7f
+		switch event.Message[0] & 0xf0 {
+		case 0x80:
+			soundingNotes[channel][note] = 0
+		case 0x90:
+			if event.Message[2] == 0 || event.Message[2] < app.MinTriggerVelocity {
+				soundingNotes[channel][note] = 0
+			} else {
+				soundingNotes[channel][note] = i + 1
+			}
+		}
+	}
+	var candidates []int
+	for channel := range soundingNotes {
+		// Ignore percussion channel
+		if channel == 9 {
+			continue
+		}
+		for _, i := range soundingNotes[channel] {
+			if i == 0 {
+				continue
+			}
+			noteOn := thisTrack[i-1]
+			noteEnd := thisTrack[len(thisTrack)-1].Microseconds.Duration()
+			for _, event := range thisTrack[index:] {
+				if len(event.Message) == 3 && event.Message[0]&0xf == noteOn.Message[0]&0xf && event.Message[1] == noteOn.Message[1] && (event.Message[0]&0xf0 == 0x80 || event.Message[0]&0xf0 == 0x90) {
+					noteEnd = event.Microseconds.Duration()
+					break
+				}
+			}
+			noteName, _ := noteIndexToName(noteOn.Message[1])
+			age := playbackProgress - noteOn.Microseconds.Duration()
+			remaining := noteEnd - playbackProgress
+			if (profile.MaxAge != 0 && age > profile.MaxAge) || remaining < profile.MinRemaining {
+				log.Printf("Chase: %s is not played again (%s old, %s left).\n", noteName, age, remaining)
+				continue
+			}
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	latest := candidates[0]
+	for _, i := range candidates[1:] {
+		this, that := thisTrack[i-1].Microseconds.Duration(), thisTrack[latest-1].Microseconds.Duration()
+		if this > that || (this == that && thisTrack[i-1].Message[1] > thisTrack[latest-1].Message[1]) {
+			latest = i
+		}
+	}
+	noteOn := thisTrack[latest-1]
+	for _, i := range candidates {
+		if i != latest {
+			noteName, _ := noteIndexToName(thisTrack[i-1].Message[1])
+			log.Printf("Chase: %s is not played again (only one note is played at a time).\n", noteName)
+		}
+	}
+	noteName, _ := noteIndexToName(noteOn.Message[1])
+	log.Printf("Chase: %s is played again (%s old).\n", noteName, playbackProgress-noteOn.Microseconds.Duration())
+	app.addMidiEvent(&midiQueueEvent{
+		Time:     now,
+		Message:  noteOn.Message,
+		Realtime: false,
+	})
+}
+
+func (app *application) setMidiPlaybackChase(profile string) error {
+	if _, ok := articulationProfiles[profile]; !ok {
+		return fmt.Errorf("unrecognized articulation profile %q", profile)
+	}
+	fmt.Printf("Set articulation profile to %s.\n", profile)
+	app.MidiPlaybackChase = profile
+	return nil
+}
+
+type midiTrackInfo struct {
+	Name       string
+	Notes      int
+	Lowest     uint8
+	Highest    uint8
+	OutOfRange int
+}
+
+// getMidiPlaybackTracks summarizes the notes of each track after the playback
+// transpose, so that the performer can choose a track that fits the instrument.
+func (app *application) getMidiPlaybackTracks() []midiTrackInfo {
+	result := make([]midiTrackInfo, len(app.midiFileBuffer.MidiTracks))
+	for trackID, track := range app.midiFileBuffer.MidiTracks {
+		info := &result[trackID]
+		info.Lowest = 0x7f
+		for _, event := range track {
+			message := event.Message
+			if len(message) > 2 && message[0] == smf.MetaStatus && message[1] == 0x03 && info.Name == "" {
+				data := message[2:]
+				for len(data) != 0 && data[0]&0x80 != 0 {
+					data = data[1:]
+				}
+				if len(data) != 0 {
+					info.Name = string(data[1:])
+				}
+				continue
+			}
+			if len(message) != 3 || message[0]&0xf0 != 0x90 || message[0]&0xf == 9 || message[2] == 0 || message[2] < app.MinTriggerVelocity {
+				continue
+			}
+			note := message[1] & 0x7f
+			info.Notes++
+			if note < info.Lowest {
+				info.Lowest = note
+			}
+			if note > info.Highest {
+				info.Highest = note
+			}
+			if len(app.keybindingCandidates(int(note))) == 0 {
+				info.OutOfRange++
+			}
+		}
+		if info.Notes == 0 {
+			info.Lowest = 0
+		}
+	}
+	return result
+}
+
 func (app *application) setMidiPlaybackTrack(trackNumber uint16) {
 	if app.MidiPlaybackTrack == trackNumber {
 		return
@@ -272,6 +524,7 @@ func (app *application) setMidiPlaybackOffset(offset time.Duration) {
 	if app.midiFileBuffer.nextEventIndex >= len(app.midiFileBuffer.MidiTracks[track]) {
 		app.midiFileBuffer.nextEventIndex = 0
 	}
+	app.midiFileBuffer.nextPrepareIndex = 0
 	app.midiFileBuffer.nextEventTimer.Reset(0)
 }
 
@@ -284,7 +537,53 @@ func (app *application) setMidiPlaybackScheduler(enabled bool, startTime time.Ti
 	app.MidiPlaybackSchedule = startTime
 	app.MidiPlaybackLoopEnabled = loopEnabled
 	app.MidiPlaybackLoop = loopInterval
+	app.MidiPlaybackPaused = false
 	app.resetMidiPlayback()
+	_ = app.KeystrokeGoro.SubmitNoWait(app.ctx, func(context.Context) (interface{}, error) {
+		app.keyStatus.playbackCounters = playbackCounters{}
+		return nil, nil
+	})
+}
+
+func (app *application) getMidiPlaybackPaused() (paused bool, position time.Duration) {
+	return app.MidiPlaybackPaused, app.midiFileBuffer.pausedProgress
+}
+
+func (app *application) setMidiPlaybackPaused(paused bool, now time.Time) error {
+	if paused == app.MidiPlaybackPaused {
+		return nil
+	}
+	if !app.MidiPlaybackScheduleEnabled {
+		return errors.New("scheduler is not enabled")
+	}
+	if paused {
+		app.midiFileBuffer.pausedProgress = now.Add(app.NtpClockOffset).Add(app.MidiPlaybackOffset).Sub(app.MidiPlaybackSchedule)
+		app.MidiPlaybackPaused = true
+		app.midiFileBuffer.nextEventTimer.Stop()
+		app.midiFileBuffer.clockOutTimer.Reset(0)
+		log.Printf("Playback paused at %s.\n", app.midiFileBuffer.pausedProgress)
+		_ = app.MidiRealtimeGoro.SubmitNoWait(app.ctx, func(context.Context) (interface{}, error) {
+			app.sendAllNoteOff(false)
+			return nil, nil
+		})
+	} else {
+		// Re-anchor the schedule so that playback continues from the same position
+		app.MidiPlaybackSchedule = now.Add(app.NtpClockOffset).Add(app.MidiPlaybackOffset).Add(-app.midiFileBuffer.pausedProgress)
+		app.MidiPlaybackPaused = false
+		log.Printf("Playback resumed at %s.\n", app.midiFileBuffer.pausedProgress)
+		track := app.MidiPlaybackTrack
+		if len(app.midiFileBuffer.MidiTracks) == 1 {
+			track = 0
+		}
+		if int(track) < len(app.midiFileBuffer.MidiTracks) && !app.midiFileBuffer.fastForward {
+			app.chaseMidiPlayback(now, app.midiFileBuffer.pausedProgress, app.midiFileBuffer.MidiTracks[track])
+		}
+		app.midiFileBuffer.nextPrepareIndex = 0
+		app.midiFileBuffer.nextEventTimer.Reset(0)
+		app.midiFileBuffer.countInTimer.Reset(0)
+		app.midiFileBuffer.clockOutTimer.Reset(0)
+	}
+	return nil
 }
 
 func (app *application) resetMidiPlayback() {
@@ -294,7 +593,13 @@ func (app *application) resetMidiPlayback() {
 		return nil, nil
 	})
 	app.midiFileBuffer.nextEventIndex = 0
+	app.midiFileBuffer.nextPrepareIndex = 0
 	app.midiFileBuffer.nextEventTimer.Reset(0)
+	app.midiFileBuffer.countInNextBeat = 0
+	app.midiFileBuffer.countInTimer.Reset(0)
+	// Song Position Pointer may only be sent while stopped
+	app.stopMidiClockOutput(app.clock.Now())
+	app.midiFileBuffer.clockOutTimer.Reset(0)
 	if !app.midiFileBuffer.fastForward {
 		log.Println("Fast-forward on.")
 		app.midiFileBuffer.fastForward = true
@@ -36,6 +36,21 @@ import (
 	"golang.org/x/sys/windows"
 )
 
+// midiInput is a MIDI input device, remembered by its name. Device is its
+// index in the device list, or -1 while it is disconnected. Channel is -1 to
+// accept every channel.
+type midiInput struct {
+	Name      string
+	Device    int
+	Channel   int
+	Transpose int
+	Error     string
+
+	hMidiIn     uintptr
+	sysexBuffer [2]*winmm.MIDIHDR
+	network     bool
+}
+
 type midiQueueEvent struct {
 	Time              time.Time
 	Expiry            time.Time
@@ -43,11 +58,23 @@ type midiQueueEvent struct {
 	Realtime          bool
 	FastForward       bool
 	AlreadyTransposed bool
+	PrepareModifiers  bool
+	Zone              *keyboardZone
+	Arpeggiated       bool
 }
 
 func (app *application) processMidiRealtime() {
+	defer app.releaseKeysOnPanic()
+	defer app.silenceMidiOutOnExit()
+	app.restoreMidiDevices()
+	pollTimer := time.NewTicker(midiDevicePollInterval)
+	defer pollTimer.Stop()
 	for {
 		select {
+		case <-pollTimer.C:
+			app.pollMidiDevices()
+		case now := <-app.arpeggiator.timer.Chan():
+			app.playNextArpeggiatorNote(now)
 		case r, ok := <-app.MidiRealtimeGoro:
 			if !ok {
 				return
@@ -72,6 +99,9 @@ func (app *application) listMidiInDevices() []string {
 		deviceName, _ := getMidiInDevName(uintptr(i))
 		results[i] = deviceName
 	}
+	if app.rtpMidiEnabled() {
+		results = append(results, app.rtpMidiDeviceName())
+	}
 	return results
 }
 
@@ -85,13 +115,90 @@ func (app *application) listMidiOutDevices() []string {
 	return results
 }
 
-func (app *application) openMidiInDevice(midiInDevice int) error {
-	app.closeMidiInDevice()
-	if midiInDevice < 0 {
-		return nil
+// setMidiInputs opens the devices listed in inputs and closes the others, so
+// that several devices can be played at once. A device that stays open keeps
+// its handle, and only its settings are updated. A device that is not plugged
+// in is opened once it appears.
+func (app *application) setMidiInputs(inputs []midiInput) error {
+	devices := app.listMidiInDevices()
+	wanted := make(map[string]bool)
+	for i := range inputs {
+		input := &inputs[i]
+		if input.Name == "" {
+			if input.Device < 0 || input.Device >= len(devices) {
+				return winmm.MidiInError(winmm.MMSYSERR_BADDEVICEID)
+			}
+			input.Name = devices[input.Device]
+		}
+		input.Name = normalizeDeviceName(input.Name)
+		if input.Channel < -1 || input.Channel > 15 {
+			return fmt.Errorf("invalid channel %d", input.Channel+1)
+		}
+		if input.Transpose < -0x7f || input.Transpose > 0x7f {
+			return fmt.Errorf("transpose %d semitones out of range", input.Transpose)
+		}
+		if wanted[input.Name] {
+			return fmt.Errorf("duplicate input device %q", input.Name)
+		}
+		wanted[input.Name] = true
+	}
+
+	opened := make(map[string]*midiInput)
+	for _, input := range app.MidiInputs {
+		if wanted[input.Name] {
+			opened[input.Name] = input
+		} else if input.connected() {
+			app.closeMidiInDevice(input)
+		}
 	}
+	// Held notes would be released with a different channel or transpose
+	app.sendAllNoteOff(true)
+
+	var firstErr error
+	results := []*midiInput{}
+	for _, settings := range inputs {
+		input := opened[settings.Name]
+		if input == nil {
+			input = &midiInput{
+				Name:   settings.Name,
+				Device: -1,
+			}
+			index := findDeviceName(devices, input.Name)
+			if index >= 0 {
+				err := app.openMidiInDevice(input, index)
+				if err != nil {
+					log.Println("Error: ", err)
+					if firstErr == nil {
+						firstErr = err
+					}
+					continue
+				}
+			}
+		}
+		input.Channel = settings.Channel
+		input.Transpose = settings.Transpose
+		results = append(results, input)
+	}
+	app.MidiInputs = results
+	err := app.saveMidiDevices()
+	if err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+func (app *application) openMidiInDevice(input *midiInput, midiInDevice int) error {
 	midiInDeviceCount := winmm.MidiInGetNumDevs()
-	if midiInDevice >= int(midiInDeviceCount) {
+	if midiInDevice == int(midiInDeviceCount) && app.rtpMidiEnabled() {
+		err := app.openRtpMidiSession(input)
+		if err != nil {
+			return err
+		}
+		input.Device = midiInDevice
+		input.network = true
+		return nil
+	}
+	if midiInDevice < 0 || midiInDevice >= int(midiInDeviceCount) {
 		return winmm.MidiInError(winmm.MMSYSERR_BADDEVICEID)
 	}
 
@@ -100,28 +207,28 @@ func (app *application) openMidiInDevice(midiInDevice int) error {
 		return err
 	}
 
-	for i := range app.sysexBuffer {
-		app.sysexBuffer[i] = &winmm.MIDIHDR{
+	input.Device = midiInDevice
+	input.hMidiIn = hMidiIn
+	for i := range input.sysexBuffer {
+		input.sysexBuffer[i] = &winmm.MIDIHDR{
 			LpData:         &new([65536]byte)[0],
 			DwBufferLength: 65536,
 		}
-		err = winmm.MidiInPrepareHeader(hMidiIn, app.sysexBuffer[i])
-		if err != nil {
-			_ = winmm.MidiInClose(hMidiIn)
-			return err
+		err = winmm.MidiInPrepareHeader(hMidiIn, input.sysexBuffer[i])
+		if err == nil {
+			err = winmm.MidiInAddBuffer(hMidiIn, input.sysexBuffer[i])
 		}
-		err = winmm.MidiInAddBuffer(hMidiIn, app.sysexBuffer[i])
 		if err != nil {
 			_ = winmm.MidiInClose(hMidiIn)
+			input.Device = -1
+			input.hMidiIn = 0
 			return err
 		}
 	}
 
-	app.MidiInDevice = midiInDevice
-	app.hMidiIn = hMidiIn
-	err = winmm.MidiInStart(app.hMidiIn)
+	err = winmm.MidiInStart(hMidiIn)
 	if err != nil {
-		app.closeMidiInDevice()
+		app.closeMidiInDevice(input)
 		return err
 	}
 
@@ -151,16 +258,31 @@ func (app *application) openMidiOutDevice(midiOutDevice int) error {
 	return nil
 }
 
-func (app *application) closeMidiInDevice() {
-	app.MidiInDevice = -1
-	if app.hMidiIn == 0 {
+func (app *application) closeMidiInDevice(input *midiInput) {
+	input.Device = -1
+	if input.network {
+		app.closeRtpMidiSession()
+		input.network = false
 		return
 	}
-	for i := range app.sysexBuffer {
-		_ = winmm.MidiInUnprepareHeader(app.hMidiIn, app.sysexBuffer[i])
+	for i := range input.sysexBuffer {
+		_ = winmm.MidiInUnprepareHeader(input.hMidiIn, input.sysexBuffer[i])
+	}
+	_ = winmm.MidiInClose(input.hMidiIn)
+	input.hMidiIn = 0
+}
+
+func (input *midiInput) connected() bool {
+	return input.network || input.hMidiIn != 0
+}
+
+func (app *application) findMidiInput(hMidiIn uintptr) *midiInput {
+	for _, input := range app.MidiInputs {
+		if !input.network && input.hMidiIn == hMidiIn {
+			return input
+		}
 	}
-	_ = winmm.MidiInClose(app.hMidiIn)
-	app.hMidiIn = 0
+	return nil
 }
 
 func (app *application) closeMidiOutDevice() {
@@ -179,11 +301,11 @@ func (app *application) closeMidiOutDevice() {
 }
 
 func (app *application) setMidiOutBank(midiOutBank uint16) {
-	app.keystrokeQueue.AddAction(&midiQueueEvent{
+	app.queueKeystroke(&midiQueueEvent{
 		Message:  []byte{0xb0, 0x00, uint8(midiOutBank>>15) & 0x7f},
 		Realtime: true,
 	}, time.Time{})
-	app.keystrokeQueue.AddAction(&midiQueueEvent{
+	app.queueKeystroke(&midiQueueEvent{
 		Message:  []byte{0xb0, 0x20, uint8(midiOutBank) & 0x7f},
 		Realtime: true,
 	}, time.Time{})
@@ -191,7 +313,7 @@ func (app *application) setMidiOutBank(midiOutBank uint16) {
 }
 
 func (app *application) setMidiOutPatch(midiOutPatch uint8) {
-	app.keystrokeQueue.AddAction(&midiQueueEvent{
+	app.queueKeystroke(&midiQueueEvent{
 		Message:  []byte{0xc0, midiOutPatch & 0x7f},
 		Realtime: true,
 	}, time.Time{})
@@ -203,28 +325,110 @@ func (app *application) setMidiOutTranspose(midiOutTranspose int) {
 	app.MidiOutTranspose = midiOutTranspose
 }
 
+// filter applies the channel filter and the transpose of the input device,
+// and returns nil if the event is filtered out.
+func (input *midiInput) filter(event []byte) []byte {
+	if input == nil || len(event) == 0 || event[0] < 0x80 || event[0] >= 0xf0 {
+		return event
+	}
+	if input.Channel >= 0 && int(event[0]&0x0f) != input.Channel {
+		return nil
+	}
+	switch event[0] & 0xf0 {
+	case 0x80, 0x90, 0xa0:
+		if input.Transpose == 0 || len(event) < 2 {
+			break
+		}
+		note := int(event[1]) + input.Transpose
+		if note < 0x00 || note > 0x7f {
+			return nil
+		}
+		event = append([]byte{}, event...)
+		event[1] = uint8(note)
+	}
+	return event
+}
+
 func (app *application) onMidiInEvent(event []byte) {
-	if len(event) == 0 {
+	if len(event) == 0 || app.handleMidiAction(event) {
 		return
 	}
-	app.addMidiEvent(&midiQueueEvent{
-		Time:     time.Now(),
+	if isExternalSyncMessage(event) {
+		app.queueExternalSync(event, app.clock.Now())
+		return
+	}
+	queueEvent := &midiQueueEvent{
+		Time:     app.clock.Now(),
 		Message:  event,
 		Realtime: true,
-	})
+	}
+	if len(app.KeyboardZones) != 0 && len(event) == 3 {
+		switch event[0] & 0xf0 {
+		case 0x80, 0x90, 0xa0:
+			app.addZonedMidiEvent(queueEvent)
+			return
+		}
+	}
+	app.addMidiEvent(queueEvent)
 }
 
 func (app *application) addMidiEvent(event *midiQueueEvent) {
+	if event.Realtime && !event.Arpeggiated && app.arpeggiator != nil && app.arpeggiator.Enabled && app.arpeggiate(event) {
+		return
+	}
+	filteredEvent := app.filterMidiEvent(event)
+	if filteredEvent == nil {
+		return
+	}
+	if filteredEvent.Zone != nil && filteredEvent.Zone.Route == "echo" {
+		app.queueMidiOut(filteredEvent, filteredEvent.Time)
+		return
+	}
+	app.queueKeystrokeWithExpiry(filteredEvent, filteredEvent.Time, filteredEvent.Expiry)
+}
+
+func (app *application) queueKeystroke(event *midiQueueEvent, t time.Time) {
+	if app.simulation != nil {
+		app.simulation.addKeystroke(event, t)
+		return
+	}
+	app.keystrokeQueue.AddAction(event, t)
+}
+
+// queueKeystrokeWithExpiry leaves the expiry to produceKeystroke instead of the
+// queue, so that an event expiring in the queue is logged.
+func (app *application) queueKeystrokeWithExpiry(event *midiQueueEvent, t, expiry time.Time) {
+	event.Expiry = expiry
+	app.queueKeystroke(event, t)
+}
+
+func (app *application) queueMidiOut(event *midiQueueEvent, t time.Time) {
+	if app.simulation != nil {
+		app.simulation.recordMidiOut(event, t)
+		return
+	}
+	app.midiOutQueue.AddAction(event, t)
+}
+
+func (app *application) minTriggerVelocity(event *midiQueueEvent) uint8 {
+	if event.Zone != nil {
+		return event.Zone.MinVelocity
+	}
+	return app.MinTriggerVelocity
+}
+
+func (app *application) filterMidiEvent(event *midiQueueEvent) *midiQueueEvent {
 	channel := event.Message[0] & 0xf
 	// Ignore percussion channel
 	if channel == 9 {
-		return
+		return nil
 	}
 	// Force channel 1
 	filteredMessage := make([]byte, len(event.Message))
 	copy(filteredMessage, event.Message)
 	filteredMessage[0] &= 0xf0
 
+	minVelocity := app.minTriggerVelocity(event)
 	expiry := event.Expiry
 	switch filteredMessage[0] {
 	// Note off
@@ -233,24 +437,24 @@ func (app *application) addMidiEvent(event *midiQueueEvent) {
 		if !event.AlreadyTransposed {
 			note += app.MidiOutTranspose
 			if note < 0x00 || note > 0x7f {
-				return
+				return nil
 			}
 			filteredMessage[1] = uint8(note)
 		}
 	// Note on
 	case 0x90:
 		if event.FastForward {
-			return
+			return nil
 		}
 		note := int(filteredMessage[1])
 		if !event.AlreadyTransposed {
 			note += app.MidiOutTranspose
 			if note < 0x00 || note > 0x7f {
-				return
+				return nil
 			}
 			filteredMessage[1] = uint8(note)
 		}
-		if filteredMessage[2] == 0 || filteredMessage[2] < app.MinTriggerVelocity {
+		if filteredMessage[2] == 0 || filteredMessage[2] < minVelocity {
 			filteredMessage[0] = 0x80
 		} else {
 			if expiry.IsZero() && !event.Time.IsZero() {
@@ -264,13 +468,13 @@ func (app *application) addMidiEvent(event *midiQueueEvent) {
 	// After touch
 	case 0xa0:
 		if event.FastForward {
-			return
+			return nil
 		}
 		note := int(filteredMessage[1])
 		if !event.AlreadyTransposed {
 			note += app.MidiOutTranspose
 			if note < 0x00 || note > 0x7f {
-				return
+				return nil
 			}
 			filteredMessage[1] = uint8(note)
 		}
@@ -289,28 +493,29 @@ func (app *application) addMidiEvent(event *midiQueueEvent) {
 	case 0xb0:
 		// Block bank select
 		if filteredMessage[1] == 0x00 || filteredMessage[1] == 0x20 {
-			return
+			return nil
 		}
 	// Program change
 	case 0xc0:
-		return
+		return nil
 	// Channel pressure
 	case 0xd0:
 		filteredMessage = filteredMessage[:2]
 	// Pitch bend
 	case 0xe0:
-		return
+		return nil
 	// System Messages
 	case 0xf0:
 	}
-	app.keystrokeQueue.AddActionWithExpiry(&midiQueueEvent{
+	return &midiQueueEvent{
 		Time:              event.Time,
 		Expiry:            expiry,
 		Message:           filteredMessage,
 		Realtime:          event.Realtime,
 		FastForward:       event.FastForward,
 		AlreadyTransposed: true,
-	}, event.Time, expiry)
+		Zone:              event.Zone,
+	}
 }
 
 func (app *application) sendMidiOutMessage(event *midiQueueEvent) error {
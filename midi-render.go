@@ -0,0 +1,139 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/algoGuy/EasyMIDI/vlq"
+)
+
+type midiRenderEvent struct {
+	Time    time.Duration
+	Message []byte
+}
+
+// renderMidiPlayback plays the selected track in a simulation, through the
+// same filters, keystroke goroutine and cooldowns as the real playback, and
+// returns what the echo synth would receive, relative to the start of the
+// song. Notes without a keybinding are dropped, as in the game, since
+// MIDI2FFXIV does not fold them into range.
+func (app *application) renderMidiPlayback() ([]midiRenderEvent, error) {
+	track := app.MidiPlaybackTrack
+	if len(app.midiFileBuffer.MidiTracks) == 1 {
+		track = 0
+	}
+	if len(app.midiFileBuffer.MidiTracks) == 0 {
+		return nil, errors.New("no MIDI file loaded")
+	}
+	if int(track) >= len(app.midiFileBuffer.MidiTracks) {
+		return nil, fmt.Errorf("invalid track number (%d), max %d", app.MidiPlaybackTrack, len(app.midiFileBuffer.MidiTracks)-1)
+	}
+
+	start := time.Unix(0, 0)
+	sim := &simulation{
+		now: start,
+	}
+	render := &application{
+		preset:            app.preset,
+		MidiOutBank:       app.MidiOutBank,
+		MidiOutPatch:      app.MidiOutPatch,
+		MidiOutTranspose:  app.MidiOutTranspose,
+		MidiPlaybackTrack: app.MidiPlaybackTrack,
+		MidiPlaybackSync:  "off",
+		KeybindingProfile: app.KeybindingProfile,
+		activeKeybindings: app.activeKeybindingProfile(),
+	}
+	render.PlaybackExtraDelay = 0
+	render.enterSimulation(sim)
+	defer render.Quit()
+	buffer := app.midiFileBuffer
+	render.midiFileBuffer.SongHash = buffer.SongHash
+	render.midiFileBuffer.SongSettings = buffer.SongSettings
+	render.midiFileBuffer.OriginalTracks = buffer.OriginalTracks
+	render.midiFileBuffer.MidiTracks = buffer.MidiTracks
+	render.midiFileBuffer.TempoTable = buffer.TempoTable
+	render.midiFileBuffer.TimeSignatureTable = buffer.TimeSignatureTable
+	render.midiFileBuffer.TicksPerBeat = buffer.TicksPerBeat
+	render.MidiPlaybackScheduleEnabled = true
+	render.MidiPlaybackSchedule = start
+	sim.run(render)
+
+	results := []midiRenderEvent{
+		{0, []byte{0xb0, 0x00, uint8(app.MidiOutBank>>15) & 0x7f}},
+		{0, []byte{0xb0, 0x20, uint8(app.MidiOutBank) & 0x7f}},
+		{0, []byte{0xc0, app.MidiOutPatch & 0x7f}},
+	}
+	for _, action := range sim.MidiOut {
+		results = append(results, midiRenderEvent{
+			Time:    action.Time.Sub(start),
+			Message: action.Event.Message,
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Time < results[j].Time
+	})
+	counters := render.keyStatus.playbackCounters
+	log.Printf("Rendered %d notes, %d delayed, %d dropped.\n", counters.Sent, counters.Delayed, counters.Dropped)
+	return results, nil
+}
+
+// writeMidiRender writes a format 0 standard MIDI file, where one tick is one
+// millisecond.
+func writeMidiRender(w io.Writer, events []midiRenderEvent) error {
+	track := []byte{
+		0x00, 0xff, 0x03, 0x0a, 'M', 'I', 'D', 'I', '2', 'F', 'F', 'X', 'I', 'V',
+		0x00, 0xff, 0x51, 0x03, 0x0f, 0x42, 0x40,
+	}
+	lastTick := int64(0)
+	for _, event := range events {
+		tick := int64(event.Time / time.Millisecond)
+		if tick < lastTick {
+			tick = lastTick
+		}
+		track = append(track, vlq.GetBytes(uint32(tick-lastTick))...)
+		track = append(track, event.Message...)
+		lastTick = tick
+	}
+	track = append(track, 0x00, 0xff, 0x2f, 0x00)
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("MThd")
+	_ = binary.Write(buf, binary.BigEndian, []uint32{6})
+	_ = binary.Write(buf, binary.BigEndian, []uint16{0, 1, 1000})
+	buf.WriteString("MTrk")
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(track)))
+	buf.Write(track)
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -34,6 +34,7 @@ import (
 )
 
 func (app *application) processNTP() {
+	defer app.releaseKeysOnPanic()
 	_ = app.NtpGoro.RunLoop(app.ctx)
 }
 
@@ -0,0 +1,116 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"./user32"
+)
+
+// In octave shift mode, the Ctrl and Shift of a keybinding mean one octave
+// down and one octave up. Instead of holding them, the octave keys of the game
+// are tapped, and the current octave of the game is remembered.
+
+func (app *application) octaveShiftEnabled() bool {
+	return app.OctaveUp != nil && app.OctaveUp.VirtualKeyCode != 0 && app.OctaveDown != nil && app.OctaveDown.VirtualKeyCode != 0
+}
+
+func (app *application) modifierCooldown() time.Duration {
+	if app.octaveShiftEnabled() {
+		return app.OctaveShiftCooldown
+	}
+	return app.ModifierCooldown
+}
+
+func keybindOctave(keybind *keybindingPreset) int {
+	if keybind.Shift && !keybind.Ctrl {
+		return 1
+	}
+	if keybind.Ctrl && !keybind.Shift {
+		return -1
+	}
+	return 0
+}
+
+// setGameOctave must run on the keystroke goroutine. It tells which octave the
+// game is in, for example after leaving performance mode in another octave.
+func (app *application) setGameOctave(octave int) error {
+	if octave < -1 || octave > 1 {
+		return fmt.Errorf("octave %d out of range", octave)
+	}
+	app.keyStatus.octave = octave
+	app.logKeystroke("octave", nil, -1, nil, 0, fmt.Sprintf("octave set to %+d", octave))
+	fmt.Printf("Game octave set to %+d.\n", octave)
+	return nil
+}
+
+func (app *application) changeOctave(keybind *keybindingPreset, now time.Time) ([]user32.INPUT_KEYBDINPUT, time.Time) {
+	pInputs := []user32.INPUT_KEYBDINPUT{}
+	target := keybindOctave(keybind)
+	for app.keyStatus.octave != target {
+		if len(pInputs) != 0 {
+			app.sendKeystrokes(pInputs)
+			pInputs = []user32.INPUT_KEYBDINPUT{}
+			waitTime := app.OctaveShiftCooldown
+			log.Printf("Octave shift cooldown %s.\n", waitTime)
+			app.clock.Sleep(waitTime)
+			now = now.Add(waitTime)
+		}
+		key := app.OctaveUp
+		if target < app.keyStatus.octave {
+			key = app.OctaveDown
+			app.keyStatus.octave--
+		} else {
+			app.keyStatus.octave++
+		}
+		scanCode := uint16(user32.MapVirtualKey(uint32(key.VirtualKeyCode), user32.MAPVK_VK_TO_VSC))
+		pInputs = append(pInputs, user32.INPUT_KEYBDINPUT{
+			Type: user32.INPUT_KEYBOARD,
+			Ki: user32.KEYBDINPUT{
+				WVk:         0,
+				WScan:       scanCode,
+				DwFlags:     user32.KEYEVENTF_SCANCODE,
+				Time:        0,
+				DwExtraInfo: 0,
+			},
+		}, user32.INPUT_KEYBDINPUT{
+			Type: user32.INPUT_KEYBOARD,
+			Ki: user32.KEYBDINPUT{
+				WVk:         0,
+				WScan:       scanCode,
+				DwFlags:     user32.KEYEVENTF_SCANCODE | user32.KEYEVENTF_KEYUP,
+				Time:        0,
+				DwExtraInfo: 0,
+			},
+		})
+		app.keyStatus.lastModifierTime = now
+		app.logKeystroke("octave", nil, -1, key, 0, fmt.Sprintf("octave %+d", app.keyStatus.octave))
+	}
+	return pInputs, now
+}
@@ -0,0 +1,256 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strings"
+)
+
+// The OSC server lets TouchOSC layouts and other tools drive MIDI2FFXIV
+// without MIDI hardware. Bundles are accepted, but their time tags are
+// ignored and their messages are handled at once.
+
+type oscMessage struct {
+	Address   string
+	Arguments []interface{}
+}
+
+func (app *application) startOscServer() error {
+	if app.OscListenAddr == "" {
+		return nil
+	}
+	addr, err := net.ResolveUDPAddr("udp", app.OscListenAddr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening for OSC messages on UDP %s.\n", conn.LocalAddr())
+	go func() {
+		<-app.ctx.Done()
+		conn.Close()
+	}()
+	go app.serveOsc(conn)
+	return nil
+}
+
+func (app *application) serveOsc(conn *net.UDPConn) {
+	defer app.releaseKeysOnPanic()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The connection is closed
+			return
+		}
+		messages, err := parseOscPacket(buf[:n])
+		if err != nil {
+			log.Println("Invalid OSC packet: ", err)
+			continue
+		}
+		for _, message := range messages {
+			err = app.onOscMessage(message)
+			if err != nil {
+				log.Printf("OSC message %s: %s\n", message.Address, err)
+			}
+		}
+	}
+}
+
+func (app *application) onOscMessage(message *oscMessage) error {
+	switch message.Address {
+	case "/note/on":
+		note, err := message.intArgument(0)
+		if err != nil {
+			return err
+		}
+		velocity := 0x7f
+		if len(message.Arguments) > 1 {
+			velocity, err = message.intArgument(1)
+			if err != nil {
+				return err
+			}
+		}
+		if note < 0x00 || note > 0x7f || velocity < 0x00 || velocity > 0x7f {
+			return errors.New("note or velocity out of range")
+		}
+		app.deliverOscMidiEvent([]byte{0x90, uint8(note), uint8(velocity)})
+	case "/note/off":
+		note, err := message.intArgument(0)
+		if err != nil {
+			return err
+		}
+		if note < 0x00 || note > 0x7f {
+			return errors.New("note out of range")
+		}
+		app.deliverOscMidiEvent([]byte{0x80, uint8(note), 0x00})
+	case "/transport/start":
+		_ = app.MidiPlaybackGoro.SubmitNoWait(app.ctx, func(context.Context) (interface{}, error) {
+			now := app.clock.Now()
+			app.setMidiPlaybackScheduler(true, now.Add(app.NtpClockOffset), app.MidiPlaybackLoopEnabled, app.MidiPlaybackLoop)
+			return nil, nil
+		})
+	case "/transport/stop":
+		_ = app.MidiPlaybackGoro.SubmitNoWait(app.ctx, func(context.Context) (interface{}, error) {
+			app.setMidiPlaybackScheduler(false, app.MidiPlaybackSchedule, app.MidiPlaybackLoopEnabled, app.MidiPlaybackLoop)
+			return nil, nil
+		})
+	case "/transpose":
+		semitones, err := message.intArgument(0)
+		if err != nil {
+			return err
+		}
+		if semitones < -0x7f || semitones > 0x7f {
+			return errors.New("transpose out of range")
+		}
+		_ = app.MidiRealtimeGoro.SubmitNoWait(app.ctx, func(context.Context) (interface{}, error) {
+			app.setMidiOutTranspose(semitones)
+			return nil, nil
+		})
+	default:
+		return errors.New("unrecognized address")
+	}
+	return nil
+}
+
+func (app *application) deliverOscMidiEvent(message []byte) {
+	_ = app.MidiRealtimeGoro.SubmitNoWait(app.ctx, func(context.Context) (interface{}, error) {
+		app.onMidiInEvent(message)
+		return nil, nil
+	})
+}
+
+// intArgument accepts floats too, since TouchOSC sends every value as a float.
+func (message *oscMessage) intArgument(index int) (int, error) {
+	if index >= len(message.Arguments) {
+		return 0, fmt.Errorf("missing argument %d", index+1)
+	}
+	switch value := message.Arguments[index].(type) {
+	case int32:
+		return int(value), nil
+	case float32:
+		return int(math.Floor(float64(value) + 0.5)), nil
+	default:
+		return 0, fmt.Errorf("argument %d is not a number", index+1)
+	}
+}
+
+func parseOscPacket(packet []byte) ([]*oscMessage, error) {
+	if len(packet) >= 8 && string(packet[:8]) == "#bundle\x00" {
+		if len(packet) < 16 {
+			return nil, errors.New("truncated bundle")
+		}
+		result := []*oscMessage{}
+		for i := 16; i < len(packet); {
+			if i+4 > len(packet) {
+				return nil, errors.New("truncated bundle")
+			}
+			size := int(binary.BigEndian.Uint32(packet[i : i+4]))
+			i += 4
+			if size < 0 || i+size > len(packet) {
+				return nil, errors.New("truncated bundle")
+			}
+			messages, err := parseOscPacket(packet[i : i+size])
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, messages...)
+			i += size
+		}
+		return result, nil
+	}
+	address, i, err := parseOscString(packet, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(address, "/") {
+		return nil, fmt.Errorf("invalid address %q", address)
+	}
+	message := &oscMessage{
+		Address:   address,
+		Arguments: []interface{}{},
+	}
+	if i >= len(packet) {
+		// Old implementations may omit the type tags of a message without arguments
+		return []*oscMessage{message}, nil
+	}
+	typeTags, i, err := parseOscString(packet, i)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(typeTags, ",") {
+		return nil, fmt.Errorf("invalid type tags %q", typeTags)
+	}
+	for _, typeTag := range typeTags[1:] {
+		switch typeTag {
+		case 'i', 'f':
+			if i+4 > len(packet) {
+				return nil, errors.New("truncated argument")
+			}
+			value := binary.BigEndian.Uint32(packet[i : i+4])
+			i += 4
+			if typeTag == 'i' {
+				message.Arguments = append(message.Arguments, int32(value))
+			} else {
+				message.Arguments = append(message.Arguments, math.Float32frombits(value))
+			}
+		case 's':
+			var value string
+			value, i, err = parseOscString(packet, i)
+			if err != nil {
+				return nil, err
+			}
+			message.Arguments = append(message.Arguments, value)
+		case 'T':
+			message.Arguments = append(message.Arguments, true)
+		case 'F':
+			message.Arguments = append(message.Arguments, false)
+		default:
+			return nil, fmt.Errorf("unsupported type tag %q", typeTag)
+		}
+	}
+	return []*oscMessage{message}, nil
+}
+
+// parseOscString reads a null terminated string, padded to 4 bytes, and
+// returns the offset after it.
+func parseOscString(packet []byte, offset int) (string, int, error) {
+	for i := offset; i < len(packet); i++ {
+		if packet[i] == 0 {
+			return string(packet[offset:i]), (i + 4) &^ 3, nil
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
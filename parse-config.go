@@ -30,6 +30,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"strconv"
@@ -42,10 +43,12 @@ func (app *application) parseConfigFile() error {
 	if err != nil {
 		log.Printf("Error: %s\n", err.Error())
 		log.Printf("Unable to load %s, default settings applied.\n", app.ConfigFile)
-		return nil
+		return app.addDefaultKeybindingProfile()
 	}
 	defer f.Close()
 	buf := bufio.NewReader(f)
+	keybinding := &app.Keybinding
+	alternatives := &app.KeybindingAlternatives
 	for {
 		line, lineerr := buf.ReadString('\n')
 		if strings.HasPrefix(line, "#") {
@@ -72,22 +75,64 @@ func (app *application) parseConfigFile() error {
 			err = app.parseConfigDuration(fields, &app.SkillCooldown)
 		case "ModifierCooldown":
 			err = app.parseConfigDuration(fields, &app.ModifierCooldown)
+		case "OctaveShiftCooldown":
+			err = app.parseConfigDuration(fields, &app.OctaveShiftCooldown)
 		case "NtpSyncTimeout":
 			err = app.parseConfigDuration(fields, &app.NtpSyncTimeout)
 		case "NtpCooldown":
 			err = app.parseConfigDuration(fields, &app.NtpCooldown)
+		case "MaxKeyHoldDuration":
+			err = app.parseConfigDuration(fields, &app.MaxKeyHoldDuration)
+		case "KeyRateLimit":
+			err = app.parseConfigUint8(fields, &app.KeyRateLimit)
+		case "KeyRateBurst":
+			err = app.parseConfigUint8(fields, &app.KeyRateBurst)
+		case "KeyRatePolicy":
+			err = app.parseConfigString(fields, &app.KeyRatePolicy)
+			if err == nil && app.KeyRatePolicy != "delay" && app.KeyRatePolicy != "drop" {
+				err = fmt.Errorf("option %q must be delay or drop", fields[0])
+			}
 		case "MinTriggerVelocity":
 			err = app.parseConfigUint8(fields, &app.MinTriggerVelocity)
 		case "Keybinding":
-			err = app.parseConfigKeybindings(fields, &app.Keybinding)
+			err = app.parseConfigKeybindings(fields, keybinding)
+		case "KeybindingAlternative":
+			err = app.parseConfigKeybindingAlternative(fields, alternatives)
+		case "KeybindingProfile":
+			var profile *keybindingProfile
+			profile, err = app.parseConfigKeybindingProfile(fields)
+			if err == nil {
+				keybinding = &profile.Keybinding
+				alternatives = &profile.Alternatives
+			}
+		case "KeyboardZone":
+			err = app.parseConfigKeyboardZone(fields)
+		case "MidiAction":
+			err = app.parseConfigMidiAction(fields)
+		case "MidiInputDevice":
+			err = app.parseConfigMidiInputDevice(fields)
+		case "MidiOutputDevice":
+			app.MidiOutputDevice = normalizeDeviceName(strings.Join(fields[1:], " "))
 		case "EmergencyStop":
 			err = app.parseConfigKeybinding(fields, &app.EmergencyStop)
+		case "OctaveUp":
+			err = app.parseConfigKeybinding(fields, &app.OctaveUp)
+		case "OctaveDown":
+			err = app.parseConfigKeybinding(fields, &app.OctaveDown)
+		case "CalendarFile":
+			err = app.parseConfigString(fields, &app.CalendarFile)
+		case "SongSettingsFile":
+			err = app.parseConfigString(fields, &app.SongSettingsFile)
 		case "WebListenAddr":
 			err = app.parseConfigString(fields, &app.WebListenAddr)
 		case "WebUsername":
 			err = app.parseConfigString(fields, &app.WebUsername)
 		case "WebPassword":
 			err = app.parseConfigString(fields, &app.WebPassword)
+		case "RtpMidiListenAddr":
+			err = app.parseConfigString(fields, &app.RtpMidiListenAddr)
+		case "OscListenAddr":
+			err = app.parseConfigString(fields, &app.OscListenAddr)
 		default:
 			err = fmt.Errorf("unrecognized option %q", fields[0])
 		}
@@ -98,7 +143,49 @@ func (app *application) parseConfigFile() error {
 			break
 		}
 	}
-	return nil
+	return app.addDefaultKeybindingProfile()
+}
+
+// saveConfigLines replaces the lines of an option in the config file, keeping
+// the other lines as they are. New lines are appended to the end. The config
+// files are edited in Notepad, so the new lines end with CRLF.
+func (app *application) saveConfigLines(option string, newLines []string) error {
+	data, err := ioutil.ReadFile(app.ConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := []string{}
+	inserted := false
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 0 && fields[0] == option {
+			if !inserted {
+				for _, i := range newLines {
+					lines = append(lines, i+"\r\n")
+				}
+				inserted = true
+			}
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if !inserted && len(newLines) != 0 {
+		if len(lines) != 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+			lines = append(lines, "\r\n")
+		}
+		lines = append(lines, "\r\n")
+		for _, i := range newLines {
+			lines = append(lines, i+"\r\n")
+		}
+	}
+	tempFile := app.ConfigFile + ".tmp"
+	err = ioutil.WriteFile(tempFile, []byte(strings.Join(lines, "")), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFile, app.ConfigFile)
 }
 
 func (app *application) parseConfigDuration(fields []string, dest *time.Duration) error {
@@ -167,6 +254,124 @@ func (app *application) parseConfigKeybinding(fields []string, dest **keybinding
 	return nil
 }
 
+// parseConfigKeybindingProfile starts a new profile, and the Keybinding lines
+// that follow belong to it.
+func (app *application) parseConfigKeybindingProfile(fields []string) (*keybindingProfile, error) {
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("syntax error in option %q", fields[0])
+	}
+	for _, profile := range app.KeybindingProfiles {
+		if profile.Name == fields[1] {
+			return nil, fmt.Errorf("duplicate keybinding profile %q", fields[1])
+		}
+	}
+	profile := &keybindingProfile{
+		Name: fields[1],
+	}
+	app.KeybindingProfiles = append(app.KeybindingProfiles, profile)
+	return profile, nil
+}
+
+// parseConfigKeyboardZone parses a line of
+// KeyboardZone <name> <lowest> <highest> <channel|all> <transpose> <min-velocity> <game|echo|control>
+func (app *application) parseConfigKeyboardZone(fields []string) error {
+	if len(fields) != 8 {
+		return fmt.Errorf("syntax error in option %q", fields[0])
+	}
+	zone := keyboardZone{
+		Name:  fields[1],
+		Route: fields[7],
+	}
+	lowest, err := noteNameToIndex(fields[2])
+	if err != nil {
+		return err
+	}
+	highest, err := noteNameToIndex(fields[3])
+	if err != nil {
+		return err
+	}
+	zone.Lowest, zone.Highest = lowest, highest
+	if !strings.EqualFold(fields[4], "all") {
+		zone.Channel, err = strconv.Atoi(fields[4])
+		if err != nil {
+			return err
+		}
+	}
+	zone.Transpose, err = strconv.Atoi(fields[5])
+	if err != nil {
+		return err
+	}
+	minVelocity, err := strconv.ParseUint(fields[6], 0, 8)
+	if err != nil {
+		return err
+	}
+	zone.MinVelocity = uint8(minVelocity)
+	zones := append(app.getKeyboardZones(), zone)
+	err = validateKeyboardZones(zones)
+	if err != nil {
+		return err
+	}
+	app.KeyboardZones = zones
+	return nil
+}
+
+// parseConfigMidiInputDevice parses a line of
+// MidiInputDevice <channel|all> <transpose> <name>
+func (app *application) parseConfigMidiInputDevice(fields []string) error {
+	if len(fields) < 4 {
+		return fmt.Errorf("syntax error in option %q", fields[0])
+	}
+	input := midiInput{
+		Name:    normalizeDeviceName(strings.Join(fields[3:], " ")),
+		Channel: -1,
+	}
+	if !strings.EqualFold(fields[1], "all") {
+		channel, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		if channel < 1 || channel > 16 {
+			return fmt.Errorf("invalid channel %d", channel)
+		}
+		input.Channel = channel - 1
+	}
+	transpose, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return err
+	}
+	input.Transpose = transpose
+	app.MidiInputDevices = append(app.MidiInputDevices, input)
+	return nil
+}
+
+// parseConfigMidiAction parses a line of
+// MidiAction <action> <cc|program|note> <channel> <number>
+func (app *application) parseConfigMidiAction(fields []string) error {
+	if len(fields) != 5 {
+		return fmt.Errorf("syntax error in option %q", fields[0])
+	}
+	binding := midiActionBinding{
+		Action:  fields[1],
+		Trigger: fields[2],
+	}
+	channel, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return err
+	}
+	binding.Channel = channel
+	number, err := strconv.ParseUint(fields[4], 0, 8)
+	if err != nil {
+		return err
+	}
+	binding.Number = uint8(number)
+	err = validateMidiActionBindings([]midiActionBinding{binding})
+	if err != nil {
+		return err
+	}
+	app.MidiActions = append(app.MidiActions, binding)
+	return nil
+}
+
 func (app *application) parseConfigKeybindings(fields []string, dest *[128]keybindingPreset) error {
 	if len(fields) < 3 {
 		return fmt.Errorf("syntax error in option %q", fields[0])
@@ -217,6 +422,19 @@ func noteIndexToName(index uint8) (string, error) {
 	return noteIndexToNameTable[index], nil
 }
 
+// parseConfigKeybindingAlternative adds another key for a note, in addition
+// to its Keybinding line.
+func (app *application) parseConfigKeybindingAlternative(fields []string, dest *[128][]keybindingPreset) error {
+	var keybinding [128]keybindingPreset
+	err := app.parseConfigKeybindings(fields, &keybinding)
+	if err != nil {
+		return err
+	}
+	noteIndex, _ := noteNameToIndex(fields[1])
+	dest[noteIndex] = append(dest[noteIndex], keybinding[noteIndex])
+	return nil
+}
+
 func noteNameToIndex(name string) (uint8, error) {
 	if index, ok := noteNameToIndexTable[name]; ok {
 		return index, nil
@@ -0,0 +1,184 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"time"
+)
+
+type playbackStatus struct {
+	State         string
+	Elapsed       time.Duration
+	Remaining     time.Duration
+	Bar           int
+	Beat          int
+	Tempo         float64
+	NextNote      string
+	NextNoteIn    time.Duration
+	LoopIteration int
+}
+
+func (app *application) getPlaybackStatus(now time.Time) *playbackStatus {
+	status := &playbackStatus{
+		State: "stopped",
+	}
+	track := app.MidiPlaybackTrack
+	if len(app.midiFileBuffer.MidiTracks) == 1 {
+		track = 0
+	}
+	if !app.MidiPlaybackScheduleEnabled || int(track) >= len(app.midiFileBuffer.MidiTracks) {
+		return status
+	}
+	thisTrack := app.midiFileBuffer.MidiTracks[track]
+	trackLength := time.Duration(0)
+	if len(thisTrack) != 0 {
+		trackLength = thisTrack[len(thisTrack)-1].Microseconds.Duration()
+	}
+
+	playbackProgress := now.Add(app.NtpClockOffset).Add(app.MidiPlaybackOffset).Sub(app.MidiPlaybackSchedule)
+	if app.MidiPlaybackPaused {
+		playbackProgress = app.midiFileBuffer.pausedProgress
+	}
+	if playbackProgress < 0 {
+		beatsPerBar, beatDuration := app.getCountIn()
+		status.State = "waiting"
+		if -playbackProgress <= time.Duration(app.MidiPlaybackCountIn*beatsPerBar)*beatDuration {
+			status.State = "counting"
+		}
+		status.Remaining = trackLength
+		_, _, status.Tempo = app.getBarBeat(0)
+		status.NextNote, status.NextNoteIn = app.findNextNote(thisTrack, 0, playbackProgress)
+		return status
+	}
+	if app.MidiPlaybackLoopEnabled && app.MidiPlaybackLoop > 0 {
+		status.LoopIteration = int(playbackProgress / app.MidiPlaybackLoop)
+		playbackProgress %= app.MidiPlaybackLoop
+	}
+
+	status.State = "playing"
+	if status.LoopIteration != 0 {
+		status.State = "looping"
+	}
+	if playbackProgress >= trackLength && !app.MidiPlaybackLoopEnabled {
+		status.State = "finished"
+		playbackProgress = trackLength
+	}
+	if app.MidiPlaybackPaused {
+		status.State = "paused"
+	}
+	status.Elapsed = playbackProgress
+	status.Remaining = trackLength - playbackProgress
+	if status.Remaining < 0 {
+		status.Remaining = 0
+	}
+	status.Bar, status.Beat, status.Tempo = app.getBarBeat(playbackProgress)
+	if status.State != "finished" {
+		index := app.midiFileBuffer.nextEventIndex
+		if app.midiFileBuffer.fastForward || app.MidiPlaybackPaused {
+			index = 0
+		}
+		status.NextNote, status.NextNoteIn = app.findNextNote(thisTrack, index, playbackProgress)
+	}
+	return status
+}
+
+// findNextNote returns the name of the first note-on at or after playbackProgress,
+// starting the search at index, and the time until it is played.
+func (app *application) findNextNote(thisTrack midiFileTrack, index int, playbackProgress time.Duration) (string, time.Duration) {
+	for _, event := range thisTrack[index:] {
+		if len(event.Message) != 3 || event.Message[0]&0xf0 != 0x90 || event.Message[0]&0xf == 9 {
+			continue
+		}
+		if event.Message[2] == 0 || event.Message[2] < app.MinTriggerVelocity {
+			continue
+		}
+		eventProgress := event.Microseconds.Duration()
+		if eventProgress < playbackProgress {
+			continue
+		}
+		noteName, _ := noteIndexToName(event.Message[1])
+		return noteName, eventProgress - playbackProgress
+	}
+	return "", 0
+}
+
+// timeSignatureTicks returns the length of a bar and of a beat in ticks. Both
+// are at least one tick, even for a short division such as 24 ticks per
+// quarter note and a time signature of x/128.
+func timeSignatureTicks(ticksPerBeat, numerator, denominator int64) (perBar, perBeat int64) {
+	perBar = ticksPerBeat * 4 * numerator / denominator
+	if perBar < 1 {
+		perBar = 1
+	}
+	perBeat = ticksPerBeat * 4 / denominator
+	if perBeat < 1 {
+		perBeat = 1
+	}
+	return
+}
+
+// getBarBeat converts a position in the song to 1-based bar and beat numbers
+// and the tempo in quarter notes per minute at that position.
+func (app *application) getBarBeat(playbackProgress time.Duration) (bar, beat int, tempo float64) {
+	ticksPerBeat := int64(app.midiFileBuffer.TicksPerBeat)
+	if ticksPerBeat == 0 {
+		return 0, 0, 0
+	}
+
+	ticks := int64(0)
+	elapsed := time.Duration(0)
+	msPerBeat := uint32(500000)
+	for _, entry := range app.midiFileBuffer.TempoTable {
+		entryTime := elapsed + time.Duration(entry.TicksElapsed-ticks)*time.Duration(msPerBeat)*time.Microsecond/time.Duration(ticksPerBeat)
+		if entryTime > playbackProgress {
+			break
+		}
+		ticks, elapsed, msPerBeat = entry.TicksElapsed, entryTime, entry.MicrosecondsPerBeat
+	}
+	ticks += int64((playbackProgress - elapsed) * time.Duration(ticksPerBeat) / (time.Duration(msPerBeat) * time.Microsecond))
+
+	barStart := int64(0)
+	bars := 0
+	numerator, denominator := int64(4), int64(4)
+	for _, entry := range app.midiFileBuffer.TimeSignatureTable {
+		if entry.TicksElapsed > ticks {
+			break
+		}
+		ticksPerBar, _ := timeSignatureTicks(ticksPerBeat, numerator, denominator)
+		bars += int((entry.TicksElapsed - barStart + ticksPerBar - 1) / ticksPerBar)
+		barStart = entry.TicksElapsed
+		numerator, denominator = int64(entry.Numerator), int64(entry.Denominator)
+		if numerator == 0 {
+			numerator = 4
+		}
+	}
+	_, ticksPerSignatureBeat := timeSignatureTicks(ticksPerBeat, numerator, denominator)
+	beats := (ticks - barStart) / ticksPerSignatureBeat
+	bar = bars + int(beats/numerator) + 1
+	beat = int(beats%numerator) + 1
+	tempo = 60e6 / float64(msPerBeat)
+	return
+}
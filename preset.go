@@ -37,7 +37,9 @@ type keybindingPreset struct {
 }
 
 type preset struct {
-	ConfigFile string
+	ConfigFile       string
+	CalendarFile     string
+	SongSettingsFile string
 
 	IdleDuration       time.Duration
 	PlaybackExtraDelay time.Duration
@@ -48,16 +50,38 @@ type preset struct {
 	NtpSyncTimeout     time.Duration
 	NtpCooldown        time.Duration
 	MinTriggerVelocity uint8
+	MaxKeyHoldDuration time.Duration
+	KeyRateLimit       uint8
+	KeyRateBurst       uint8
+	KeyRatePolicy      string
 	Keybinding         [128]keybindingPreset
 	EmergencyStop      *keybindingPreset
 
+	KeybindingAlternatives [128][]keybindingPreset
+	KeybindingProfiles     []*keybindingProfile
+
+	OctaveUp            *keybindingPreset
+	OctaveDown          *keybindingPreset
+	OctaveShiftCooldown time.Duration
+
+	KeyboardZones []keyboardZone
+	MidiActions   []midiActionBinding
+
+	MidiInputDevices []midiInput
+	MidiOutputDevice string
+
 	WebListenAddr string
 	WebUsername   string
 	WebPassword   string
+
+	RtpMidiListenAddr string
+	OscListenAddr     string
 }
 
 var defaultPreset = preset{
 	ConfigFile:         "midi2ffxiv.conf",
+	CalendarFile:       "midi2ffxiv_calendar.json",
+	SongSettingsFile:   "midi2ffxiv_songs.json",
 	IdleDuration:       1000 * time.Millisecond,
 	PlaybackExtraDelay: 1500 * time.Millisecond,
 	RealtimeMaxLatency: 300 * time.Millisecond,
@@ -67,6 +91,10 @@ var defaultPreset = preset{
 	NtpSyncTimeout:     5 * time.Second,
 	NtpCooldown:        10 * time.Second,
 	MinTriggerVelocity: 16,
+	MaxKeyHoldDuration: 30 * time.Second,
+	KeyRateLimit:       10,
+	KeyRateBurst:       20,
+	KeyRatePolicy:      "delay",
 	Keybinding: [128]keybindingPreset{
 		0x30: {true, false, false, 'Q'},
 		0x31: {true, false, false, '2'},
@@ -110,7 +138,13 @@ var defaultPreset = preset{
 		0x54: {false, false, true, 'I'},
 	},
 	EmergencyStop: &keybindingPreset{true, true, true, 0xdb},
+
+	OctaveShiftCooldown: 50 * time.Millisecond,
+
 	WebListenAddr: ":65300",
 	WebUsername:   "",
 	WebPassword:   "",
+
+	RtpMidiListenAddr: ":5004",
+	OscListenAddr:     "",
 }
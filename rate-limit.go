@@ -0,0 +1,76 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"time"
+)
+
+// keyRateLimiter is a token bucket of key presses. It protects the game
+// server from dense MIDI files and runaway controllers, whatever the
+// cooldowns are set to. A rate of 0 disables it.
+type keyRateLimiter struct {
+	tokens  float64
+	updated time.Time
+	Delayed int
+	Dropped int
+}
+
+func (limiter *keyRateLimiter) refill(now time.Time, rate, burst uint8) {
+	capacity := float64(burst)
+	if capacity < 1 {
+		capacity = 1
+	}
+	if limiter.updated.IsZero() {
+		limiter.tokens = capacity
+	} else if now.After(limiter.updated) {
+		limiter.tokens += now.Sub(limiter.updated).Seconds() * float64(rate)
+		if limiter.tokens > capacity {
+			limiter.tokens = capacity
+		}
+	}
+	limiter.updated = now
+}
+
+// wait returns how long the next key press has to wait for a token.
+func (limiter *keyRateLimiter) wait(now time.Time, rate, burst uint8) time.Duration {
+	if rate == 0 {
+		return 0
+	}
+	limiter.refill(now, rate, burst)
+	if limiter.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - limiter.tokens) / float64(rate) * float64(time.Second))
+}
+
+func (limiter *keyRateLimiter) take(now time.Time, rate, burst uint8) {
+	if rate == 0 {
+		return
+	}
+	limiter.refill(now, rate, burst)
+	limiter.tokens--
+}
@@ -0,0 +1,330 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// An RTP-MIDI (AppleMIDI) session lets an iPad or a DAW on the LAN play as a
+// MIDI input device. The session listens on a control port and on the data
+// port right after it, accepts every invitation, answers clock
+// synchronization, and ignores the recovery journal.
+
+const rtpMidiSessionName = "MIDI2FFXIV"
+
+type rtpMidiSession struct {
+	app     *application
+	input   *midiInput
+	control *net.UDPConn
+	data    *net.UDPConn
+	ssrc    uint32
+	start   time.Time
+	mutex   sync.Mutex
+	peers   map[uint32]*rtpMidiPeer
+}
+
+type rtpMidiPeer struct {
+	name          string
+	control       *net.UDPAddr
+	runningStatus byte
+}
+
+func (app *application) rtpMidiEnabled() bool {
+	return app.RtpMidiListenAddr != ""
+}
+
+func (app *application) rtpMidiDeviceName() string {
+	return fmt.Sprintf("Network session (RTP-MIDI, %s)", app.RtpMidiListenAddr)
+}
+
+func (app *application) openRtpMidiSession(input *midiInput) error {
+	controlAddr, err := net.ResolveUDPAddr("udp", app.RtpMidiListenAddr)
+	if err != nil {
+		return err
+	}
+	dataAddr := *controlAddr
+	dataAddr.Port++
+	control, err := net.ListenUDP("udp", controlAddr)
+	if err != nil {
+		return err
+	}
+	data, err := net.ListenUDP("udp", &dataAddr)
+	if err != nil {
+		control.Close()
+		return err
+	}
+	session := &rtpMidiSession{
+		app:     app,
+		input:   input,
+		control: control,
+		data:    data,
+		ssrc:    rand.Uint32(),
+		start:   time.Now(),
+		peers:   make(map[uint32]*rtpMidiPeer),
+	}
+	app.rtpMidiSession = session
+	go session.serve(control, false)
+	go session.serve(data, true)
+	log.Printf("RTP-MIDI session %q listening on UDP %s.\n", rtpMidiSessionName, control.LocalAddr())
+	return nil
+}
+
+func (app *application) closeRtpMidiSession() {
+	session := app.rtpMidiSession
+	if session == nil {
+		return
+	}
+	app.rtpMidiSession = nil
+	session.mutex.Lock()
+	for _, peer := range session.peers {
+		_, _ = session.control.WriteToUDP(session.command("BY", 0), peer.control)
+	}
+	session.mutex.Unlock()
+	session.control.Close()
+	session.data.Close()
+}
+
+func (session *rtpMidiSession) serve(conn *net.UDPConn, isData bool) {
+	defer session.app.releaseKeysOnPanic()
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The connection is closed
+			return
+		}
+		packet := buf[:n]
+		if len(packet) >= 4 && packet[0] == 0xff && packet[1] == 0xff {
+			session.handleCommand(conn, addr, packet, isData)
+		} else if isData {
+			session.handleRtp(packet)
+		}
+	}
+}
+
+// command builds an exchange packet, which is also the start of a clock
+// synchronization packet.
+func (session *rtpMidiSession) command(name string, token uint32) []byte {
+	packet := []byte{0xff, 0xff, name[0], name[1]}
+	packet = append(packet, 0, 0, 0, 2)
+	packet = append(packet, byte(token>>24), byte(token>>16), byte(token>>8), byte(token))
+	packet = append(packet, byte(session.ssrc>>24), byte(session.ssrc>>16), byte(session.ssrc>>8), byte(session.ssrc))
+	if name == "OK" {
+		packet = append(packet, rtpMidiSessionName...)
+		packet = append(packet, 0)
+	}
+	return packet
+}
+
+func (session *rtpMidiSession) handleCommand(conn *net.UDPConn, addr *net.UDPAddr, packet []byte, isData bool) {
+	switch string(packet[2:4]) {
+	case "IN":
+		if len(packet) < 16 {
+			return
+		}
+		token := binary.BigEndian.Uint32(packet[8:12])
+		if binary.BigEndian.Uint32(packet[4:8]) != 2 {
+			_, _ = conn.WriteToUDP(session.command("NO", token), addr)
+			return
+		}
+		ssrc := binary.BigEndian.Uint32(packet[12:16])
+		name := packet[16:]
+		for i, c := range name {
+			if c == 0 {
+				name = name[:i]
+				break
+			}
+		}
+		session.mutex.Lock()
+		peer, ok := session.peers[ssrc]
+		if !ok {
+			peer = &rtpMidiPeer{}
+			session.peers[ssrc] = peer
+		}
+		peer.name = string(name)
+		if !isData {
+			peer.control = addr
+		}
+		session.mutex.Unlock()
+		_, _ = conn.WriteToUDP(session.command("OK", token), addr)
+		if isData {
+			log.Printf("RTP-MIDI session with %q (%s) started.\n", name, addr)
+		}
+	case "CK":
+		if len(packet) < 36 || packet[8] != 0 {
+			return
+		}
+		reply := make([]byte, 36)
+		copy(reply, packet)
+		binary.BigEndian.PutUint32(reply[4:8], session.ssrc)
+		reply[8] = 1
+		binary.BigEndian.PutUint64(reply[20:28], uint64(time.Since(session.start)/(100*time.Microsecond)))
+		_, _ = conn.WriteToUDP(reply, addr)
+	case "BY":
+		if len(packet) < 16 {
+			return
+		}
+		ssrc := binary.BigEndian.Uint32(packet[12:16])
+		session.mutex.Lock()
+		peer, ok := session.peers[ssrc]
+		delete(session.peers, ssrc)
+		session.mutex.Unlock()
+		if ok {
+			log.Printf("RTP-MIDI session with %q ended.\n", peer.name)
+			// Notes held by the peer would never be released otherwise
+			app := session.app
+			_ = app.MidiRealtimeGoro.SubmitNoWait(app.ctx, func(context.Context) (interface{}, error) {
+				app.sendAllNoteOff(true)
+				return nil, nil
+			})
+		}
+	}
+}
+
+func (session *rtpMidiSession) handleRtp(packet []byte) {
+	if len(packet) < 13 || packet[0]>>6 != 2 {
+		return
+	}
+	headerLength := 12 + 4*int(packet[0]&0x0f)
+	ssrc := binary.BigEndian.Uint32(packet[8:12])
+	if len(packet) <= headerLength {
+		return
+	}
+	payload := packet[headerLength:]
+	flags := payload[0]
+	length := int(flags & 0x0f)
+	offset := 1
+	if flags&0x80 != 0 {
+		if len(payload) < 2 {
+			return
+		}
+		length = length<<8 | int(payload[1])
+		offset = 2
+	}
+	if offset+length > len(payload) {
+		return
+	}
+	session.mutex.Lock()
+	peer, ok := session.peers[ssrc]
+	var messages [][]byte
+	if ok {
+		messages = parseRtpMidiCommandList(payload[offset:offset+length], flags&0x20 != 0, &peer.runningStatus)
+	}
+	session.mutex.Unlock()
+	for _, message := range messages {
+		session.deliver(message)
+	}
+}
+
+func (session *rtpMidiSession) deliver(message []byte) {
+	app := session.app
+	_ = app.MidiRealtimeGoro.SubmitNoWait(app.ctx, func(context.Context) (interface{}, error) {
+		app.onMidiInEvent(session.input.filter(message))
+		return nil, nil
+	})
+}
+
+// parseRtpMidiCommandList splits a MIDI command list into messages. Delta
+// times are skipped, since realtime input is played as soon as it arrives,
+// and segmented system exclusive messages are dropped.
+func parseRtpMidiCommandList(list []byte, firstHasDelta bool, runningStatus *byte) [][]byte {
+	result := [][]byte{}
+	for i, first := 0, true; i < len(list); first = false {
+		if !first || firstHasDelta {
+			for j := 0; j < 4 && i < len(list); j++ {
+				i++
+				if list[i-1]&0x80 == 0 {
+					break
+				}
+			}
+		}
+		if i >= len(list) {
+			break
+		}
+		status := list[i]
+		if status&0x80 != 0 {
+			i++
+		} else if *runningStatus != 0 {
+			status = *runningStatus
+		} else {
+			break
+		}
+		switch {
+		case status >= 0xf8:
+			result = append(result, []byte{status})
+		case status == 0xf0:
+			j := i
+			for j < len(list) && list[j]&0x80 == 0 {
+				j++
+			}
+			if j < len(list) && list[j] == 0xf7 {
+				result = append(result, append([]byte{0xf0}, list[i:j+1]...))
+			}
+			i = j + 1
+			*runningStatus = 0
+		case status == 0xf7 || status == 0xf4:
+			for i < len(list) && list[i]&0x80 == 0 {
+				i++
+			}
+			i++
+			*runningStatus = 0
+		case status >= 0xf0:
+			n := 0
+			switch status {
+			case 0xf1, 0xf3:
+				n = 1
+			case 0xf2:
+				n = 2
+			}
+			if i+n > len(list) {
+				return result
+			}
+			result = append(result, append([]byte{status}, list[i:i+n]...))
+			i += n
+			*runningStatus = 0
+		default:
+			n := 2
+			if status&0xf0 == 0xc0 || status&0xf0 == 0xd0 {
+				n = 1
+			}
+			if i+n > len(list) {
+				return result
+			}
+			result = append(result, append([]byte{status}, list[i:i+n]...))
+			i += n
+			*runningStatus = status
+		}
+	}
+	return result
+}
@@ -0,0 +1,260 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	cgc "github.com/m13253/cgc-go"
+)
+
+// simulation runs MIDI file playback, the keystroke goroutine and their
+// queues on a single goroutine in virtual time, and records the keystrokes
+// that would be sent to the game, and the messages that would be sent to the
+// echo synth, instead of sending them.
+type simulation struct {
+	now        time.Time
+	timers     []*virtualTimer
+	keystrokes []*simulatedAction
+	Timeline   []simulatedKeystroke
+	MidiOut    []*simulatedAction
+}
+
+type simulatedAction struct {
+	Time  time.Time
+	Event *midiQueueEvent
+}
+
+type simulatedKeystroke struct {
+	Time        time.Time
+	PressedKeys string
+}
+
+type virtualTimer struct {
+	sim      *simulation
+	deadline time.Time
+	active   bool
+}
+
+func (sim *simulation) Now() time.Time {
+	return sim.now
+}
+
+func (sim *simulation) Sleep(d time.Duration) {
+	sim.now = sim.now.Add(d)
+}
+
+func (sim *simulation) NewTimer(d time.Duration) clockTimer {
+	timer := &virtualTimer{sim: sim}
+	timer.Reset(d)
+	sim.timers = append(sim.timers, timer)
+	return timer
+}
+
+func (t *virtualTimer) Chan() <-chan time.Time {
+	return nil
+}
+
+func (t *virtualTimer) Reset(d time.Duration) bool {
+	wasActive := t.active
+	t.deadline = t.sim.now.Add(d)
+	t.active = true
+	return wasActive
+}
+
+func (t *virtualTimer) Stop() bool {
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func (sim *simulation) addKeystroke(event *midiQueueEvent, t time.Time) {
+	if t.Before(sim.now) {
+		t = sim.now
+	}
+	i := sort.Search(len(sim.keystrokes), func(i int) bool {
+		return sim.keystrokes[i].Time.After(t)
+	})
+	sim.keystrokes = append(sim.keystrokes, nil)
+	copy(sim.keystrokes[i+1:], sim.keystrokes[i:])
+	sim.keystrokes[i] = &simulatedAction{t, event}
+}
+
+func (sim *simulation) recordKeystrokes(now time.Time, pressedKeys string) {
+	sim.Timeline = append(sim.Timeline, simulatedKeystroke{now, pressedKeys})
+}
+
+func (sim *simulation) recordMidiOut(event *midiQueueEvent, t time.Time) {
+	sim.MidiOut = append(sim.MidiOut, &simulatedAction{t, event})
+}
+
+// run processes timers and queued events in order of time until nothing is
+// left to do. On ties, playback goes before keystrokes, as in real time the
+// playback goroutine queues an event before it is due.
+func (sim *simulation) run(app *application) {
+	for {
+		for _, goro := range []cgc.Executor{app.MidiPlaybackGoro, app.MidiRealtimeGoro, app.KeystrokeGoro} {
+			for drained := false; !drained; {
+				select {
+				case r := <-goro:
+					_ = cgc.RunOneRequest(app.ctx, r)
+				default:
+					drained = true
+				}
+			}
+		}
+
+		var nextTime time.Time
+		var nextAction func(now time.Time)
+		consider := func(t time.Time, action func(now time.Time)) {
+			if nextAction == nil || t.Before(nextTime) {
+				nextTime, nextAction = t, action
+			}
+		}
+		considerTimer := func(t clockTimer, action func(now time.Time)) {
+			timer := t.(*virtualTimer)
+			if timer.active {
+				consider(timer.deadline, func(now time.Time) {
+					timer.active = false
+					action(now)
+				})
+			}
+		}
+		considerTimer(app.midiFileBuffer.nextEventTimer, app.playNextMidiEvent)
+		considerTimer(app.midiFileBuffer.countInTimer, app.playNextCountInClick)
+		considerTimer(app.midiFileBuffer.clockOutTimer, app.runMidiClockOutput)
+		if len(sim.keystrokes) != 0 {
+			consider(sim.keystrokes[0].Time, func(now time.Time) {
+				next := sim.keystrokes[0]
+				sim.keystrokes = sim.keystrokes[1:]
+				app.produceKeystroke(next.Event)
+			})
+		}
+		considerTimer(app.keyStatus.clearModifiersTimer, app.clearModifiers)
+		considerTimer(app.keyStatus.stuckKeyTimer, app.releaseStuckKeys)
+
+		if nextAction == nil {
+			return
+		}
+		if nextTime.After(sim.now) {
+			sim.now = nextTime
+		}
+		nextAction(sim.now)
+	}
+}
+
+// runSimulation implements "midi2ffxiv simulate <file.mid> [track]", which
+// prints the keystrokes that playing the file would send, in virtual time.
+func (app *application) runSimulation(args []string) int {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: midi2ffxiv simulate <file.mid> [track]")
+		return 2
+	}
+	track := uint64(1)
+	if len(args) > 1 {
+		var err error
+		track, err = strconv.ParseUint(args[1], 0, 16)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: ", err)
+			return 2
+		}
+	}
+	app.preset = defaultPreset
+	err := app.parseConfigFile()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: ", err)
+		return 1
+	}
+	sim := &simulation{
+		now: time.Unix(0, 0),
+	}
+	timeline, counters, err := app.simulate(sim, args[0], uint16(track))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: ", err)
+		return 1
+	}
+	start := time.Unix(0, 0)
+	for _, keystroke := range timeline {
+		fmt.Printf("%10.3f %s\n", keystroke.Time.Sub(start).Seconds(), keystroke.PressedKeys)
+	}
+	fmt.Fprintf(os.Stderr, "Notes sent: %d, delayed: %d, dropped: %d.\n", counters.Sent, counters.Delayed, counters.Dropped)
+	return 0
+}
+
+func (app *application) simulate(sim *simulation, fileName string, track uint16) ([]simulatedKeystroke, playbackCounters, error) {
+	app.enterSimulation(sim)
+	defer app.Quit()
+	app.MidiOutTranspose = 0
+	app.MidiPlaybackTrack = track
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, playbackCounters{}, err
+	}
+	err = app.setMidiPlaybackFile(f)
+	f.Close()
+	if err != nil {
+		return nil, playbackCounters{}, err
+	}
+	if len(app.midiFileBuffer.MidiTracks) != 1 && int(track) >= len(app.midiFileBuffer.MidiTracks) {
+		return nil, playbackCounters{}, errors.New("invalid track number")
+	}
+
+	app.MidiPlaybackScheduleEnabled = true
+	app.MidiPlaybackSchedule = sim.now
+	sim.run(app)
+	return sim.Timeline, app.keyStatus.playbackCounters, nil
+}
+
+// enterSimulation replaces the goroutines, clock and timers of app with ones
+// driven by sim, and leaves an empty MIDI file buffer.
+func (app *application) enterSimulation(sim *simulation) {
+	app.ctx, app.Quit = context.WithCancel(context.Background())
+	app.KeystrokeGoro = cgc.NewBuffered(1)
+	app.MidiRealtimeGoro = cgc.NewBuffered(1)
+	app.MidiPlaybackGoro = cgc.NewBuffered(1)
+	app.clock = sim
+	app.simulation = sim
+
+	app.MidiPlaybackChase = "plucked"
+	app.midiFileBuffer = &midiFileBuffer{
+		nextEventTimer: sim.NewTimer(0),
+		countInTimer:   sim.NewTimer(0),
+		clockOutTimer:  sim.NewTimer(0),
+	}
+	app.calendar = &calendar{}
+	app.keyStatus = &keystrokeStatus{
+		clearModifiersTimer: sim.NewTimer(app.IdleDuration),
+		stuckKeyTimer:       sim.NewTimer(app.MaxKeyHoldDuration),
+		lastNote:            0xff,
+	}
+}
@@ -0,0 +1,129 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func simulateFixture(t *testing.T, fileName string) ([]simulatedKeystroke, playbackCounters) {
+	app := new(application)
+	app.preset = defaultPreset
+	app.SongSettingsFile = ""
+	err := app.addDefaultKeybindingProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := &simulation{
+		now: time.Unix(0, 0),
+	}
+	timeline, counters, err := app.simulate(sim, fileName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return timeline, counters
+}
+
+// testdata/scale.mid plays C4, D4 and E4 as quarter notes at 120 BPM.
+func TestSimulateScale(t *testing.T) {
+	timeline, counters := simulateFixture(t, "testdata/scale.mid")
+	if counters != (playbackCounters{Sent: 3}) {
+		t.Errorf("counters = %+v, want 3 sent", counters)
+	}
+	// C4, D4 and E4 are bound to Q, W and E without modifiers by default
+	want := []string{"[ 'Q' ]", "[ 'W' ]", "[ 'E' ]"}
+	pressed := []time.Time{}
+	for _, keystroke := range timeline {
+		if len(pressed) < len(want) && keystroke.PressedKeys == want[len(pressed)] {
+			pressed = append(pressed, keystroke.Time)
+		}
+	}
+	if len(pressed) != len(want) {
+		t.Fatalf("timeline %v does not press %v in order", timeline, want)
+	}
+	for i := 1; i < len(pressed); i++ {
+		if gap := pressed[i].Sub(pressed[i-1]); gap != 500*time.Millisecond {
+			t.Errorf("%s pressed %s after %s, want 500ms", want[i], gap, want[i-1])
+		}
+	}
+	if last := timeline[len(timeline)-1].PressedKeys; last != "[ ]" {
+		t.Errorf("%s still held at the end", last)
+	}
+}
+
+func TestSimulateDeterministic(t *testing.T) {
+	first, _ := simulateFixture(t, "testdata/scale.mid")
+	second, _ := simulateFixture(t, "testdata/scale.mid")
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("timelines differ:\n%v\n%v", first, second)
+	}
+}
+
+// The render runs the same simulation, so it plays the scale with the same
+// timing as the keystrokes.
+func TestRenderMidiPlayback(t *testing.T) {
+	app := new(application)
+	app.preset = defaultPreset
+	app.SongSettingsFile = ""
+	err := app.addDefaultKeybindingProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.enterSimulation(&simulation{
+		now: time.Unix(0, 0),
+	})
+	defer app.Quit()
+	f, err := os.Open("testdata/scale.mid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.setMidiPlaybackFile(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := app.renderMidiPlayback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	noteOns := []time.Duration{}
+	for _, event := range events {
+		if len(event.Message) == 3 && event.Message[0]&0xf0 == 0x90 && event.Message[2] != 0 {
+			noteOns = append(noteOns, event.Time)
+		}
+	}
+	if len(noteOns) != 3 {
+		t.Fatalf("rendered %d notes, want 3", len(noteOns))
+	}
+	for i := 1; i < len(noteOns); i++ {
+		if gap := noteOns[i] - noteOns[i-1]; gap != 500*time.Millisecond {
+			t.Errorf("note %d rendered %s after the previous one, want 500ms", i+1, gap)
+		}
+	}
+}
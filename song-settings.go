@@ -0,0 +1,160 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// songSettings is stored per song, keyed by the SHA-256 hash of the MIDI file.
+type songSettings struct {
+	Humanize  humanizeSettings  `json:"humanize"`
+	Transpose transposeSettings `json:"transpose"`
+}
+
+type transposeSettings struct {
+	Semitones int `json:"semitones"`
+	Octaves   int `json:"octaves"`
+}
+
+func (app *application) loadSongSettingsFile() (map[string]songSettings, error) {
+	songs := map[string]songSettings{}
+	if app.SongSettingsFile == "" {
+		return songs, nil
+	}
+	data, err := ioutil.ReadFile(app.SongSettingsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return songs, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(data, &songs)
+	if err != nil {
+		return nil, err
+	}
+	return songs, nil
+}
+
+func (app *application) loadSongSettings(songHash string) songSettings {
+	songs, err := app.loadSongSettingsFile()
+	if err != nil {
+		log.Println("Error: ", err)
+		log.Printf("Unable to load %s, using default song settings.\n", app.SongSettingsFile)
+		return songSettings{}
+	}
+	return songs[songHash]
+}
+
+func (app *application) saveSongSettings() error {
+	if app.midiFileBuffer.SongHash == "" {
+		return errors.New("no MIDI file loaded")
+	}
+	if app.SongSettingsFile == "" {
+		return nil
+	}
+	songs, err := app.loadSongSettingsFile()
+	if err != nil {
+		return err
+	}
+	songs[app.midiFileBuffer.SongHash] = app.midiFileBuffer.SongSettings
+	data, err := json.MarshalIndent(songs, "", "    ")
+	if err != nil {
+		return err
+	}
+	tempFile := app.SongSettingsFile + ".tmp"
+	err = ioutil.WriteFile(tempFile, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFile, app.SongSettingsFile)
+}
+
+// loadMidiTracks applies the song settings to the tracks as they were read
+// from the MIDI file.
+func (app *application) loadMidiTracks() {
+	settings := app.midiFileBuffer.SongSettings
+	tracks := app.transposeMidiTracks(app.midiFileBuffer.OriginalTracks, settings.Transpose.Semitones+12*settings.Transpose.Octaves)
+	app.midiFileBuffer.MidiTracks = app.humanizeMidiTracks(tracks)
+}
+
+func (app *application) getPlaybackTranspose() transposeSettings {
+	return app.midiFileBuffer.SongSettings.Transpose
+}
+
+func (app *application) setPlaybackTranspose(settings transposeSettings) error {
+	if app.midiFileBuffer.SongHash == "" {
+		return errors.New("no MIDI file loaded")
+	}
+	total := settings.Semitones + 12*settings.Octaves
+	if total < -0x7f || total > 0x7f {
+		return fmt.Errorf("transpose %d semitones out of range", total)
+	}
+	oldSettings := app.midiFileBuffer.SongSettings.Transpose
+	app.midiFileBuffer.SongSettings.Transpose = settings
+	err := app.saveSongSettings()
+	if err != nil {
+		app.midiFileBuffer.SongSettings.Transpose = oldSettings
+		return err
+	}
+	fmt.Printf("Set playback transpose to %d semitones.\n", total)
+	app.loadMidiTracks()
+	app.resetMidiPlayback()
+	return nil
+}
+
+// transposeMidiTracks returns a copy of the tracks with all notes moved by a
+// number of semitones. Notes moved out of the MIDI range are removed.
+func (app *application) transposeMidiTracks(tracks []midiFileTrack, semitones int) []midiFileTrack {
+	if semitones == 0 {
+		return tracks
+	}
+	result := make([]midiFileTrack, len(tracks))
+	for trackID, track := range tracks {
+		transposed := make(midiFileTrack, 0, len(track))
+		for _, event := range track {
+			message := event.Message
+			if len(message) != 3 || message[0]&0xf == 9 || (message[0]&0xf0 != 0x80 && message[0]&0xf0 != 0x90 && message[0]&0xf0 != 0xa0) {
+				transposed = append(transposed, event)
+				continue
+			}
+			note := int(message[1]) + semitones
+			if note < 0x00 || note > 0x7f {
+				continue
+			}
+			transposedEvent := *event
+			transposedEvent.Message = []byte{message[0], uint8(note), message[2]}
+			transposed = append(transposed, &transposedEvent)
+		}
+		result[trackID] = transposed
+	}
+	return result
+}
@@ -0,0 +1,193 @@
+// +build windows
+
+/*
+   MIDI2FFXIV
+   Copyright (C) 2017-2018 Star Brilliant <m13253@hotmail.com>
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+   FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+   DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"log"
+	"runtime/debug"
+	"time"
+
+	actionqueue "github.com/m13253/actionqueue-go"
+
+	"./kernel32"
+	"./user32"
+)
+
+// keyReleaseTimeout is how long shutdown waits for the keystroke goroutine to
+// release the keys held in the game.
+const keyReleaseTimeout = 2 * time.Second
+
+// releaseStuckKeys runs when the stuck key timer fires. A key held longer than
+// MaxKeyHoldDuration is released, in case its note off was lost, and the timer
+// is armed again for the next key that may get stuck.
+func (app *application) releaseStuckKeys(now time.Time) {
+	if app.MaxKeyHoldDuration == 0 {
+		return
+	}
+	pInputs := []user32.INPUT_KEYBDINPUT{}
+	var nextDeadline time.Time
+	for i := 0; i < 256; i++ {
+		if !app.keyStatus.pressedKeys[i].Pressed {
+			continue
+		}
+		deadline := app.keyStatus.pressedKeys[i].LastPress.Add(app.MaxKeyHoldDuration)
+		if deadline.After(now) {
+			if nextDeadline.IsZero() || deadline.Before(nextDeadline) {
+				nextDeadline = deadline
+			}
+			continue
+		}
+		log.Printf("Key %q held for too long, releasing.\n", rune(i))
+		pInputs = append(pInputs, user32.INPUT_KEYBDINPUT{
+			Type: user32.INPUT_KEYBOARD,
+			Ki: user32.KEYBDINPUT{
+				WVk:         0,
+				WScan:       uint16(user32.MapVirtualKey(uint32(i), user32.MAPVK_VK_TO_VSC)),
+				DwFlags:     user32.KEYEVENTF_SCANCODE | user32.KEYEVENTF_KEYUP,
+				Time:        0,
+				DwExtraInfo: 0,
+			},
+		})
+		app.keyStatus.pressedKeys[i].Pressed = false
+		app.keyStatus.pressedKeys[i].LastChange = now
+		app.keyStatus.pressedKeys[i].LastRelease = now
+		app.keyStatus.pressedKeysCount--
+		app.logKeystroke("release", nil, int(app.keyStatus.pressedKeys[i].MidiNote), &keybindingPreset{VirtualKeyCode: uint8(i)}, 0, "held for too long")
+	}
+	if len(pInputs) != 0 {
+		if app.keyStatus.pressedKeysCount == 0 {
+			app.keyStatus.pendingModifiers = nil
+			app.keyStatus.clearModifiersTimer.Reset(app.IdleDuration)
+		}
+		app.sendKeystrokes(pInputs)
+	}
+	if !nextDeadline.IsZero() {
+		app.keyStatus.stuckKeyTimer.Reset(nextDeadline.Sub(now))
+	}
+}
+
+// releaseAllInputs leaves nothing held in the game, and brings the octave of
+// the game back to the middle in octave shift mode.
+func (app *application) releaseAllInputs(now time.Time, reason string) {
+	app.logKeystroke("release", nil, -1, nil, 0, reason)
+	pInputs := app.releaseAllKeys(now)
+	if len(pInputs) != 0 {
+		app.sendKeystrokes(pInputs)
+	}
+	app.clearModifiers(now)
+	if app.octaveShiftEnabled() {
+		pInputs, _ = app.changeOctave(&keybindingPreset{}, now)
+		if len(pInputs) != 0 {
+			app.sendKeystrokes(pInputs)
+		}
+	}
+}
+
+// releaseKeysOnExit is deferred by the keystroke goroutine, so the keys are
+// released whether it quits or panics. The waiters are woken up even if
+// releasing panics again, for example because the key state is broken.
+func (app *application) releaseKeysOnExit() {
+	defer close(app.keysReleased)
+	r := recover()
+	if r != nil {
+		log.Printf("Panic: %v\n%s", r, debug.Stack())
+	}
+	if count := drainActionQueue(app.keystrokeQueue); count != 0 {
+		log.Printf("Discarded %d queued keystroke events.\n", count)
+	}
+	log.Println("Releasing all keys.")
+	app.releaseAllInputs(app.clock.Now(), "shutdown")
+	if r != nil {
+		panic(r)
+	}
+}
+
+// silenceMidiOutOnExit is deferred by the realtime goroutine, so that no note
+// queued for the echo synth keeps sounding after exit.
+func (app *application) silenceMidiOutOnExit() {
+	if count := drainActionQueue(app.midiOutQueue); count != 0 {
+		log.Printf("Discarded %d queued MIDI output events.\n", count)
+	}
+	for channel := uint8(0); channel < 16; channel++ {
+		err := app.sendMidiOutMessage(&midiQueueEvent{
+			Message: []byte{0xb0 | channel, 0x7b, 0x00},
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			return
+		}
+	}
+}
+
+// drainActionQueue discards the actions that are due in queue.
+func drainActionQueue(queue *actionqueue.Queue) int {
+	count := 0
+	for {
+		select {
+		case action, ok := <-queue.NextAction():
+			if !ok || action == nil {
+				return count
+			}
+			count++
+		default:
+			return count
+		}
+	}
+}
+
+// releaseKeysOnPanic is deferred by the other goroutines. A panic there would
+// crash the process with keys still held in the game, so the keystroke
+// goroutine is stopped first.
+func (app *application) releaseKeysOnPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Printf("Panic: %v\n%s", r, debug.Stack())
+	app.Quit()
+	app.waitForKeysReleased()
+	panic(r)
+}
+
+func (app *application) waitForKeysReleased() {
+	select {
+	case <-app.keysReleased:
+	case <-time.After(keyReleaseTimeout):
+		log.Println("Timed out releasing keys.")
+	}
+}
+
+// onConsoleCtrl is called on its own thread when the console window is
+// closed, and the process is killed once it returns.
+func (app *application) onConsoleCtrl(dwCtrlType uint32) uintptr {
+	switch dwCtrlType {
+	case kernel32.CTRL_C_EVENT, kernel32.CTRL_BREAK_EVENT, kernel32.CTRL_CLOSE_EVENT, kernel32.CTRL_LOGOFF_EVENT, kernel32.CTRL_SHUTDOWN_EVENT:
+		app.Quit()
+		app.waitForKeysReleased()
+		return 1
+	}
+	return 0
+}
@@ -36,9 +36,12 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"syscall"
 	"time"
+
+	"./user32"
 )
 
 type webHandlers struct {
@@ -72,12 +75,31 @@ func (app *application) startWebServer() error {
 	h.serveMux.HandleFunc("/midi-output-bank", h.midiOutputBank)
 	h.serveMux.HandleFunc("/midi-output-patch", h.midiOutputPatch)
 	h.serveMux.HandleFunc("/midi-output-transpose", h.midiOutputTranspose)
+	h.serveMux.HandleFunc("/midi-output-clock", h.midiOutputClock)
+	h.serveMux.HandleFunc("/keybinding-profile", h.keybindingProfile)
+	h.serveMux.HandleFunc("/game-octave", h.gameOctave)
+	h.serveMux.HandleFunc("/keyboard-zones", h.keyboardZones)
+	h.serveMux.HandleFunc("/midi-actions", h.midiActions)
+	h.serveMux.HandleFunc("/midi-learn", h.midiLearn)
+	h.serveMux.HandleFunc("/arpeggiator", h.arpeggiator)
 	h.serveMux.HandleFunc("/current-time", h.currentTime)
 	h.serveMux.HandleFunc("/ntp-sync-server", h.ntpSyncServer)
 	h.serveMux.HandleFunc("/midi-playback-file", h.midiPlaybackFile)
 	h.serveMux.HandleFunc("/midi-playback-track", h.midiPlaybackTrack)
+	h.serveMux.HandleFunc("/midi-playback-tracks", h.midiPlaybackTracks)
+	h.serveMux.HandleFunc("/midi-playback-transpose", h.midiPlaybackTranspose)
 	h.serveMux.HandleFunc("/midi-playback-offset", h.midiPlaybackOffset)
+	h.serveMux.HandleFunc("/midi-playback-render", h.midiPlaybackRender)
 	h.serveMux.HandleFunc("/scheduler", h.scheduler)
+	h.serveMux.HandleFunc("/midi-playback-pause", h.midiPlaybackPause)
+	h.serveMux.HandleFunc("/midi-playback-count-in", h.midiPlaybackCountIn)
+	h.serveMux.HandleFunc("/midi-playback-chase", h.midiPlaybackChase)
+	h.serveMux.HandleFunc("/midi-playback-sync", h.midiPlaybackSync)
+	h.serveMux.HandleFunc("/midi-playback-humanize", h.midiPlaybackHumanize)
+	h.serveMux.HandleFunc("/calendar", h.calendar)
+	h.serveMux.HandleFunc("/playback-status", h.playbackStatus)
+	h.serveMux.HandleFunc("/keystroke-log", h.keystrokeLog)
+	h.serveMux.HandleFunc("/keystroke-log-stream", h.keystrokeLogStream)
 
 	originalAddr, err := net.ResolveTCPAddr("tcp", app.WebListenAddr)
 	availableAddr := new(net.TCPAddr)
@@ -154,6 +176,18 @@ func (h *webHandlers) version(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, result)
 }
 
+type midiInputItem struct {
+	Name      string `json:"name"`
+	Device    int    `json:"device"`
+	Channel   *int   `json:"channel"`
+	Transpose int    `json:"transpose"`
+	Connected bool   `json:"connected"`
+	Error     string `json:"error"`
+}
+
+// midiInputDevice accepts either a list of input devices with their settings,
+// or a single device number, where -1 closes every device. Devices in the
+// list are chosen by name, or by number if the name is empty.
 func (h *webHandlers) midiInputDevice(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "PUT" {
 		body, err := ioutil.ReadAll(r.Body)
@@ -162,14 +196,31 @@ func (h *webHandlers) midiInputDevice(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), 500)
 			return
 		}
-		value, err := strconv.ParseInt(string(body), 0, 32)
-		if err != nil {
-			log.Println("Error: ", err)
-			http.Error(w, err.Error(), 400)
-			return
+		var items []midiInputItem
+		if value, err := strconv.ParseInt(string(body), 0, 32); err == nil {
+			if value >= 0 {
+				items = append(items, midiInputItem{Device: int(value)})
+			}
+		} else {
+			err = json.Unmarshal(body, &items)
+			if err != nil {
+				log.Println("Error: ", err)
+				http.Error(w, err.Error(), 400)
+				return
+			}
+		}
+		inputs := make([]midiInput, len(items))
+		for i, item := range items {
+			inputs[i].Name = item.Name
+			inputs[i].Device = item.Device
+			inputs[i].Channel = -1
+			if item.Channel != nil {
+				inputs[i].Channel = *item.Channel - 1
+			}
+			inputs[i].Transpose = item.Transpose
 		}
 		_, err = h.app.MidiRealtimeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
-			return nil, h.app.openMidiInDevice(int(value))
+			return nil, h.app.setMidiInputs(inputs)
 		})
 		if err != nil {
 			log.Println("Error: ", err)
@@ -179,12 +230,26 @@ func (h *webHandlers) midiInputDevice(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var result struct {
-		Devices  []string `json:"devices"`
-		Selected int      `json:"selected"`
+		Devices []string        `json:"devices"`
+		Inputs  []midiInputItem `json:"inputs"`
 	}
 	h.app.MidiRealtimeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
 		result.Devices = h.app.listMidiInDevices()
-		result.Selected = h.app.MidiInDevice
+		result.Inputs = []midiInputItem{}
+		for _, input := range h.app.MidiInputs {
+			item := midiInputItem{
+				Name:      input.Name,
+				Device:    input.Device,
+				Transpose: input.Transpose,
+				Connected: input.connected(),
+				Error:     input.Error,
+			}
+			if input.Channel >= 0 {
+				item.Channel = new(int)
+				*item.Channel = input.Channel + 1
+			}
+			result.Inputs = append(result.Inputs, item)
+		}
 		return nil, nil
 	})
 	writeJSON(w, result)
@@ -205,7 +270,7 @@ func (h *webHandlers) midiOutputDevice(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		_, err = h.app.MidiRealtimeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
-			return nil, h.app.openMidiOutDevice(int(value))
+			return nil, h.app.setMidiOutDevice(int(value))
 		})
 		if err != nil {
 			log.Println("Error: ", err)
@@ -215,12 +280,18 @@ func (h *webHandlers) midiOutputDevice(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var result struct {
-		Devices  []string `json:"devices"`
-		Selected int      `json:"selected"`
+		Devices   []string `json:"devices"`
+		Selected  int      `json:"selected"`
+		Name      string   `json:"name"`
+		Connected bool     `json:"connected"`
+		Error     string   `json:"error"`
 	}
 	h.app.MidiRealtimeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
 		result.Devices = h.app.listMidiOutDevices()
 		result.Selected = h.app.MidiOutDevice
+		result.Name = h.app.MidiOutDeviceName
+		result.Connected = h.app.hMidiOut != 0
+		result.Error = h.app.midiOutError
 		return nil, nil
 	})
 	writeJSON(w, result)
@@ -321,6 +392,36 @@ func (h *webHandlers) midiOutputTranspose(w http.ResponseWriter, r *http.Request
 	writeJSON(w, result)
 }
 
+func (h *webHandlers) midiOutputClock(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		value, err := strconv.ParseBool(string(body))
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			h.app.setMidiOutClock(value)
+			return nil, nil
+		})
+	}
+
+	var result struct {
+		Enabled bool `json:"enabled"`
+	}
+	h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result.Enabled = h.app.MidiOutClock
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
 func (h *webHandlers) currentTime(w http.ResponseWriter, r *http.Request) {
 	now := time.Now()
 	var result struct {
@@ -412,6 +513,75 @@ func (h *webHandlers) midiPlaybackTrack(w http.ResponseWriter, r *http.Request)
 	writeJSON(w, result)
 }
 
+func (h *webHandlers) midiPlaybackTracks(w http.ResponseWriter, r *http.Request) {
+	type trackInfo struct {
+		Track      int     `json:"track"`
+		Name       string  `json:"name"`
+		Notes      int     `json:"notes"`
+		Lowest     *string `json:"lowest"`
+		Highest    *string `json:"highest"`
+		OutOfRange int     `json:"out_of_range"`
+	}
+	var result struct {
+		Tracks    []trackInfo `json:"tracks"`
+		Transpose int         `json:"transpose"`
+	}
+	result.Tracks = []trackInfo{}
+	h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		for i, info := range h.app.getMidiPlaybackTracks() {
+			track := trackInfo{
+				Track:      i,
+				Name:       info.Name,
+				Notes:      info.Notes,
+				OutOfRange: info.OutOfRange,
+			}
+			if info.Notes != 0 {
+				lowest, _ := noteIndexToName(info.Lowest)
+				highest, _ := noteIndexToName(info.Highest)
+				track.Lowest, track.Highest = &lowest, &highest
+			}
+			result.Tracks = append(result.Tracks, track)
+		}
+		transpose := h.app.getPlaybackTranspose()
+		result.Transpose = transpose.Semitones + 12*transpose.Octaves
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+func (h *webHandlers) midiPlaybackTranspose(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		var settings transposeSettings
+		err = json.Unmarshal(body, &settings)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		_, err = h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			return nil, h.app.setPlaybackTranspose(settings)
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	var result transposeSettings
+	h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result = h.app.getPlaybackTranspose()
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
 func (h *webHandlers) midiPlaybackOffset(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "PUT" {
 		body, err := ioutil.ReadAll(r.Body)
@@ -442,6 +612,33 @@ func (h *webHandlers) midiPlaybackOffset(w http.ResponseWriter, r *http.Request)
 	writeJSON(w, result)
 }
 
+func (h *webHandlers) midiPlaybackRender(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		var events []midiRenderEvent
+		_, err := h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			var err error
+			events, err = h.app.renderMidiPlayback()
+			return nil, err
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 503)
+			return
+		}
+
+		w.Header().Set("Content-Type", "audio/midi")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"midi2ffxiv-render.mid\"")
+		w.Header().Set("Cache-Control", "no-cache")
+		err = writeMidiRender(w, events)
+		if err != nil {
+			log.Println("Error: ", err)
+		}
+		return
+	}
+
+	http.Error(w, "Method Not Allowed", 405)
+}
+
 func (h *webHandlers) scheduler(w http.ResponseWriter, r *http.Request) {
 	var result struct {
 		Enabled      bool     `json:"enabled"`
@@ -488,6 +685,617 @@ func (h *webHandlers) scheduler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, result)
 }
 
+func (h *webHandlers) midiPlaybackPause(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		value, err := strconv.ParseBool(string(body))
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		_, err = h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			return nil, h.app.setMidiPlaybackPaused(value, time.Now())
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 503)
+			return
+		}
+	}
+
+	var result struct {
+		Paused   bool     `json:"paused"`
+		Position *float64 `json:"position"`
+	}
+	h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		var position time.Duration
+		result.Paused, position = h.app.getMidiPlaybackPaused()
+		if result.Paused {
+			result.Position = new(float64)
+			*result.Position = float64(position/time.Nanosecond) * 1e-9
+		}
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+func (h *webHandlers) midiPlaybackCountIn(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		value, err := strconv.ParseUint(string(body), 0, 8)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		_, err = h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			h.app.setMidiPlaybackCountIn(int(value))
+			return nil, nil
+		})
+	}
+
+	var result struct {
+		Bars         int     `json:"bars"`
+		BeatsPerBar  int     `json:"beats_per_bar"`
+		BeatDuration float64 `json:"beat_duration"`
+	}
+	h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		var beatDuration time.Duration
+		result.Bars = h.app.MidiPlaybackCountIn
+		result.BeatsPerBar, beatDuration = h.app.getCountIn()
+		result.BeatDuration = float64(beatDuration/time.Nanosecond) * 1e-9
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+func (h *webHandlers) midiPlaybackChase(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		_, err = h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			return nil, h.app.setMidiPlaybackChase(string(body))
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	var result struct {
+		Profiles []string `json:"profiles"`
+		Selected string   `json:"selected"`
+	}
+	for name := range articulationProfiles {
+		result.Profiles = append(result.Profiles, name)
+	}
+	sort.Strings(result.Profiles)
+	h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result.Selected = h.app.MidiPlaybackChase
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+func (h *webHandlers) midiPlaybackSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		_, err = h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			return nil, h.app.setMidiPlaybackSync(string(body))
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	var result struct {
+		Modes    []string `json:"modes"`
+		Selected string   `json:"selected"`
+		Running  bool     `json:"running"`
+	}
+	result.Modes = externalSyncModes
+	h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result.Selected = h.app.MidiPlaybackSync
+		result.Running = h.app.externalSync.running
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+func (h *webHandlers) gameOctave(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		value, err := strconv.Atoi(string(body))
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		_, err = h.app.KeystrokeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			return nil, h.app.setGameOctave(value)
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	var result struct {
+		Enabled bool `json:"enabled"`
+		Octave  int  `json:"octave"`
+	}
+	result.Enabled = h.app.octaveShiftEnabled()
+	h.app.KeystrokeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result.Octave = h.app.keyStatus.octave
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+func (h *webHandlers) keybindingProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		_, err = h.app.KeystrokeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			return nil, h.app.setKeybindingProfile(string(body))
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	var result struct {
+		Profiles []string `json:"profiles"`
+		Selected string   `json:"selected"`
+	}
+	result.Profiles = h.app.getKeybindingProfiles()
+	h.app.KeystrokeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result.Selected = h.app.KeybindingProfile
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+func (h *webHandlers) keyboardZones(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		var zones []keyboardZone
+		err = json.Unmarshal(body, &zones)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		_, err = h.app.MidiRealtimeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			return nil, h.app.setKeyboardZones(zones)
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	var result struct {
+		Zones   []keyboardZone `json:"zones"`
+		Actions []string       `json:"actions"`
+	}
+	h.app.MidiRealtimeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result.Zones = h.app.getKeyboardZones()
+		return nil, nil
+	})
+	result.Actions = controlActions
+	writeJSON(w, result)
+}
+
+func (h *webHandlers) midiActions(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		var bindings []midiActionBinding
+		err = json.Unmarshal(body, &bindings)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		_, err = h.app.MidiRealtimeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			return nil, h.app.setMidiActions(bindings)
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	var result struct {
+		Actions  []string            `json:"actions"`
+		Bindings []midiActionBinding `json:"bindings"`
+		Learning string              `json:"learning"`
+	}
+	result.Actions = controlActions
+	h.app.MidiRealtimeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result.Bindings = h.app.getMidiActions()
+		result.Learning = h.app.midiLearnAction
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+// midiLearn binds the action in the request body to the next message from
+// the input devices. An empty body cancels learning.
+func (h *webHandlers) midiLearn(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		_, err = h.app.MidiRealtimeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			return nil, h.app.setMidiLearnAction(string(body))
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	var result struct {
+		Learning string `json:"learning"`
+	}
+	h.app.MidiRealtimeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result.Learning = h.app.midiLearnAction
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+func (h *webHandlers) arpeggiator(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		var settings arpeggiatorSettings
+		err = json.Unmarshal(body, &settings)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		_, err = h.app.MidiRealtimeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			return nil, h.app.setArpeggiator(settings)
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	var result struct {
+		arpeggiatorSettings
+		Patterns []string `json:"patterns"`
+	}
+	result.Patterns = arpeggiatorPatterns
+	h.app.MidiRealtimeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result.arpeggiatorSettings = h.app.getArpeggiator()
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+func (h *webHandlers) midiPlaybackHumanize(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		var settings humanizeSettings
+		err = json.Unmarshal(body, &settings)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		_, err = h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			return nil, h.app.setHumanize(settings)
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	var result humanizeSettings
+	h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result = h.app.getHumanize()
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+func (h *webHandlers) calendar(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		var entries []calendarEntry
+		err = json.Unmarshal(body, &entries)
+		if err == nil {
+			err = validateCalendarEntries(entries)
+		}
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		_, err = h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+			return nil, h.app.setCalendar(entries)
+		})
+		if err != nil {
+			log.Println("Error: ", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	type nextEntry struct {
+		Entry     int     `json:"entry"`
+		Name      string  `json:"name"`
+		StartTime float64 `json:"start_time"`
+	}
+	var result struct {
+		Entries []calendarEntry `json:"entries"`
+		Next    *nextEntry      `json:"next"`
+	}
+	_, ntpOffset, _ := h.app.getNtpOffset()
+	h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result.Entries = append([]calendarEntry{}, h.app.getCalendar()...)
+		index, startTime := h.app.nextCalendarEntry(time.Now().Add(ntpOffset))
+		if index != -1 {
+			startTime = startTime.UTC()
+			result.Next = &nextEntry{
+				Entry:     index,
+				Name:      result.Entries[index].Name,
+				StartTime: float64(startTime.Unix()) + float64(startTime.Nanosecond())*1e-9,
+			}
+		}
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+func (h *webHandlers) playbackStatus(w http.ResponseWriter, r *http.Request) {
+	var result struct {
+		State         string   `json:"state"`
+		Elapsed       float64  `json:"elapsed"`
+		Remaining     float64  `json:"remaining"`
+		Bar           int      `json:"bar"`
+		Beat          int      `json:"beat"`
+		Tempo         float64  `json:"tempo"`
+		NextNote      *string  `json:"next_note"`
+		NextNoteIn    *float64 `json:"next_note_in"`
+		LoopIteration int      `json:"loop_iteration"`
+		NotesSent     int      `json:"notes_sent"`
+		NotesDelayed  int      `json:"notes_delayed"`
+		NotesDropped  int      `json:"notes_dropped"`
+		RateDelayed   int      `json:"rate_limit_delayed"`
+		RateDropped   int      `json:"rate_limit_dropped"`
+	}
+	h.app.MidiPlaybackGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		status := h.app.getPlaybackStatus(time.Now())
+		result.State = status.State
+		result.Elapsed = float64(status.Elapsed/time.Nanosecond) * 1e-9
+		result.Remaining = float64(status.Remaining/time.Nanosecond) * 1e-9
+		result.Bar = status.Bar
+		result.Beat = status.Beat
+		result.Tempo = status.Tempo
+		if status.NextNote != "" {
+			result.NextNote = new(string)
+			*result.NextNote = status.NextNote
+			result.NextNoteIn = new(float64)
+			*result.NextNoteIn = float64(status.NextNoteIn/time.Nanosecond) * 1e-9
+		}
+		result.LoopIteration = status.LoopIteration
+		return nil, nil
+	})
+	h.app.KeystrokeGoro.Submit(h.app.ctx, func(context.Context) (interface{}, error) {
+		result.NotesSent = h.app.keyStatus.playbackCounters.Sent
+		result.NotesDelayed = h.app.keyStatus.playbackCounters.Delayed
+		result.NotesDropped = h.app.keyStatus.playbackCounters.Dropped
+		result.RateDelayed = h.app.keyStatus.rateLimiter.Delayed
+		result.RateDropped = h.app.keyStatus.rateLimiter.Dropped
+		return nil, nil
+	})
+	writeJSON(w, result)
+}
+
+type keystrokeLogItem struct {
+	Seq        uint64   `json:"seq"`
+	Time       float64  `json:"time"`
+	Intended   *float64 `json:"intended"`
+	Action     string   `json:"action"`
+	Note       *string  `json:"note"`
+	Realtime   bool     `json:"realtime"`
+	VirtualKey *uint8   `json:"virtual_key"`
+	ScanCode   *uint16  `json:"scan_code"`
+	Modifiers  []string `json:"modifiers"`
+	Wait       float64  `json:"wait"`
+	Reason     string   `json:"reason"`
+}
+
+// keystrokeLogItems converts the entries for JSON, with times on the
+// synchronized clock, so that the logs of a band can be compared.
+func (h *webHandlers) keystrokeLogItems(entries []keystrokeLogEntry) []keystrokeLogItem {
+	_, offset, _ := h.app.getNtpOffset()
+	result := make([]keystrokeLogItem, len(entries))
+	for i, entry := range entries {
+		item := &result[i]
+		item.Seq = entry.Seq
+		t := entry.Time.Add(offset)
+		item.Time = float64(t.Unix()) + float64(t.Nanosecond())*1e-9
+		if !entry.Intended.IsZero() {
+			t = entry.Intended.Add(offset)
+			item.Intended = new(float64)
+			*item.Intended = float64(t.Unix()) + float64(t.Nanosecond())*1e-9
+		}
+		item.Action = entry.Action
+		if entry.Note >= 0 {
+			item.Note = new(string)
+			*item.Note, _ = noteIndexToName(uint8(entry.Note))
+		}
+		item.Realtime = entry.Realtime
+		if entry.VirtualKey != 0 {
+			item.VirtualKey = new(uint8)
+			*item.VirtualKey = entry.VirtualKey
+			item.ScanCode = new(uint16)
+			*item.ScanCode = uint16(user32.MapVirtualKey(uint32(entry.VirtualKey), user32.MAPVK_VK_TO_VSC))
+		}
+		item.Modifiers = []string{}
+		if entry.Ctrl {
+			item.Modifiers = append(item.Modifiers, "Ctrl")
+		}
+		if entry.Alt {
+			item.Modifiers = append(item.Modifiers, "Alt")
+		}
+		if entry.Shift {
+			item.Modifiers = append(item.Modifiers, "Shift")
+		}
+		item.Wait = float64(entry.Wait/time.Nanosecond) * 1e-9
+		item.Reason = entry.Reason
+	}
+	return result
+}
+
+func parseKeystrokeLogSince(r *http.Request) (uint64, error) {
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		since = r.Header.Get("Last-Event-ID")
+	}
+	if since == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(since, 10, 64)
+}
+
+func (h *webHandlers) keystrokeLog(w http.ResponseWriter, r *http.Request) {
+	since, err := parseKeystrokeLogSince(r)
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var result struct {
+		Entries []keystrokeLogItem `json:"entries"`
+		Last    uint64             `json:"last"`
+	}
+	entries, _ := h.app.keystrokeLog.since(since)
+	result.Entries = h.keystrokeLogItems(entries)
+	result.Last = since
+	if len(entries) != 0 {
+		result.Last = entries[len(entries)-1].Seq
+	}
+	writeJSON(w, result)
+}
+
+// keystrokeLogStream sends the keystroke log as server-sent events, starting
+// after the entry given by "since" or by the Last-Event-ID of a reconnecting
+// client.
+func (h *webHandlers) keystrokeLogStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", 500)
+		return
+	}
+	since, err := parseKeystrokeLogSince(r)
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		entries, changed := h.app.keystrokeLog.since(since)
+		for _, item := range h.keystrokeLogItems(entries) {
+			stream, err := json.Marshal(item)
+			if err != nil {
+				log.Println("Error: ", err)
+				return
+			}
+			_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", item.Seq, stream)
+			if err != nil {
+				return
+			}
+			since = item.Seq
+		}
+		flusher.Flush()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-h.app.ctx.Done():
+			return
+		}
+	}
+}
+
 func writeJSON(w http.ResponseWriter, v interface{}) {
 	stream, err := json.Marshal(v)
 	if err != nil {
@@ -78,18 +78,57 @@
         });
     }
 
+    function addMidiInputSettings(container, device, text, channel, transpose) {
+        var label = document.createElement("label");
+        label.className = "pure-u-1 padding-input";
+        label.htmlFor = "midi-input-channel-" + device;
+        label.textContent = text;
+        container.appendChild(label);
+        var select = document.createElement("select");
+        select.className = "pure-u-1-2 round-left";
+        select.id = "midi-input-channel-" + device;
+        addSelectOption(select, "All channels", "");
+        for (var i = 1; i <= 16; i++) {
+            addSelectOption(select, "Channel " + i, i);
+        }
+        select.value = channel === null ? "" : channel;
+        select.addEventListener("change", onMidiInputDeviceChanged);
+        container.appendChild(select);
+        var input = document.createElement("input");
+        input.className = "pure-u-1-2 round-right";
+        input.type = "number";
+        input.id = "midi-input-transpose-" + device;
+        input.min = -127;
+        input.max = 127;
+        input.placeholder = "Transpose";
+        input.value = transpose;
+        input.addEventListener("change", onMidiInputDeviceChanged);
+        container.appendChild(input);
+    }
+
     function doMidiInputRefresh(quiet) {
         requestHTTP("GET", "/midi-input-device", null, function onLoad(event, response) {
             var list = document.getElementById("midi-input-device");
+            var container = document.getElementById("midi-input-settings");
             suppressEvents = true;
             try {
                 clearSelect(list);
-                addSelectOption(list, "(None)", "-1");
+                clearSelect(container);
                 var devices = response["devices"];
                 for (var i = 0; i < devices.length; i++) {
-                    addSelectOption(list, devices[i], i);
+                    addSelectOption(list, devices[i], devices[i]);
                 }
-                list.value = response["selected"];
+                response["inputs"].forEach(function (input) {
+                    var option = list.options[input["device"]];
+                    var text = input["name"];
+                    if (!input["connected"]) {
+                        addSelectOption(list, input["name"] + " (disconnected)", input["name"]);
+                        option = list.lastElementChild;
+                        text += input["error"] ? " (" + input["error"] + ")" : " (disconnected)";
+                    }
+                    option.selected = true;
+                    addMidiInputSettings(container, option.index, text, input["channel"], input["transpose"]);
+                });
             } finally {
                 suppressEvents = false;
             }
@@ -105,17 +144,173 @@
         return doMidiInputRefresh(false);
     }
 
+    function collectMidiInputs() {
+        var list = document.getElementById("midi-input-device");
+        var inputs = [];
+        for (var i = 0; i < list.options.length; i++) {
+            var option = list.options[i];
+            if (!option.selected) { continue; }
+            var channel = document.getElementById("midi-input-channel-" + i);
+            var transpose = document.getElementById("midi-input-transpose-" + i);
+            inputs.push({
+                "name": option.value,
+                "channel": channel && channel.value !== "" ? +channel.value : null,
+                "transpose": transpose ? parseInt(transpose.value || "0", 10) : 0
+            });
+        }
+        return inputs;
+    }
+
     function onMidiInputDeviceChanged() {
         if (suppressEvents) { return; }
-        var value = this.value;
-        var text = this.options[this.selectedIndex].text;
-        requestHTTP("PUT", "/midi-input-device", value, function onLoad(event, response) {
-            reportMessage("MIDI input device changed to " + text + ".");
+        var inputs = collectMidiInputs();
+        requestHTTP("PUT", "/midi-input-device", JSON.stringify(inputs), function onLoad(event, response) {
+            reportMessage("MIDI input devices changed, " + inputs.length + " selected.");
+            doMidiInputRefresh(true);
         }, function onError(event, error) {
             reportError(error);
+            doMidiInputRefresh(true);
         })
     }
 
+    function doArpeggiatorRefresh() {
+        requestHTTP("GET", "/arpeggiator", null, function onLoad(event, response) {
+            document.getElementById("arp-enabled").checked = response["enabled"];
+            document.getElementById("arp-pattern").value = response["pattern"];
+            document.getElementById("arp-octaves").value = response["octaves"];
+            document.getElementById("arp-tempo").value = response["tempo"];
+            document.getElementById("arp-division").value = response["division"];
+            document.getElementById("arp-latch").checked = response["latch"];
+        }, function onError(event, error) {
+        });
+    }
+
+    function onArpeggiatorChanged() {
+        if (suppressEvents) { return; }
+        var settings = {
+            "enabled": document.getElementById("arp-enabled").checked,
+            "pattern": document.getElementById("arp-pattern").value,
+            "octaves": parseInt(document.getElementById("arp-octaves").value || "1", 10),
+            "tempo": parseFloat(document.getElementById("arp-tempo").value || "0"),
+            "division": parseInt(document.getElementById("arp-division").value, 10),
+            "latch": document.getElementById("arp-latch").checked
+        };
+        requestHTTP("PUT", "/arpeggiator", JSON.stringify(settings), function onLoad(event, response) {
+            reportMessage("Arpeggiator " + (response["enabled"] ? "enabled, " + response["pattern"] : "disabled") + ".");
+        }, function onError(event, error) {
+            reportError(error);
+            doArpeggiatorRefresh();
+        });
+    }
+
+    var midiActionBindings = [];
+
+    function describeMidiActionBindings(action) {
+        var triggers = {
+            "cc": "CC ",
+            "program": "Program ",
+            "note": "Note "
+        };
+        var text = [];
+        midiActionBindings.forEach(function (binding) {
+            if (binding["action"] === action) {
+                text.push(triggers[binding["trigger"]] + binding["number"] + " (ch " + binding["channel"] + ")");
+            }
+        });
+        return text.length !== 0 ? text.join(", ") : "(None)";
+    }
+
+    function doMidiActionsRefresh(poll) {
+        requestHTTP("GET", "/midi-actions", null, function onLoad(event, response) {
+            var container = document.getElementById("midi-actions");
+            midiActionBindings = response["bindings"];
+            if (!container.firstElementChild) {
+                response["actions"].forEach(function (action) {
+                    var label = document.createElement("span");
+                    label.className = "pure-u-1-2 padding-input";
+                    label.id = "midi-action-" + action;
+                    container.appendChild(label);
+                    var learn = document.createElement("input");
+                    learn.className = "pure-u-1-4 pure-button round-left";
+                    learn.type = "button";
+                    learn.value = "Learn";
+                    learn.dataset.action = action;
+                    learn.addEventListener("click", onMidiActionLearnClicked);
+                    container.appendChild(learn);
+                    var clear = document.createElement("input");
+                    clear.className = "pure-u-1-4 pure-button round-right";
+                    clear.type = "button";
+                    clear.value = "Clear";
+                    clear.dataset.action = action;
+                    clear.addEventListener("click", onMidiActionClearClicked);
+                    container.appendChild(clear);
+                });
+            }
+            response["actions"].forEach(function (action) {
+                var text = action + ": " + describeMidiActionBindings(action);
+                if (response["learning"] === action) {
+                    text = action + ": waiting for MIDI\u2026";
+                }
+                document.getElementById("midi-action-" + action).textContent = text;
+            });
+            if (poll && response["learning"] !== "") {
+                setTimeout(doMidiActionsRefresh, 500, true);
+            }
+        }, function onError(event, error) {
+        });
+    }
+
+    function onMidiActionLearnClicked() {
+        var action = this.dataset.action;
+        requestHTTP("PUT", "/midi-learn", action, function onLoad(event, response) {
+            reportMessage("Press a key, pad or knob on the MIDI device to trigger " + action + ".");
+            doMidiActionsRefresh(true);
+        }, function onError(event, error) {
+            reportError(error);
+        });
+    }
+
+    function onMidiActionClearClicked() {
+        var action = this.dataset.action;
+        var bindings = midiActionBindings.filter(function (binding) {
+            return binding["action"] !== action;
+        });
+        requestHTTP("PUT", "/midi-actions", JSON.stringify(bindings), function onLoad(event, response) {
+            reportMessage("MIDI action " + action + " cleared.");
+            doMidiActionsRefresh();
+        }, function onError(event, error) {
+            reportError(error);
+        });
+    }
+
+    function doKeybindingProfileRefresh() {
+        requestHTTP("GET", "/keybinding-profile", null, function onLoad(event, response) {
+            var list = document.getElementById("keybinding-profile");
+            suppressEvents = true;
+            try {
+                clearSelect(list);
+                var profiles = response["profiles"];
+                for (var i = 0; i < profiles.length; i++) {
+                    addSelectOption(list, profiles[i], profiles[i]);
+                }
+                list.value = response["selected"];
+            } finally {
+                suppressEvents = false;
+            }
+        }, function onError(event, error) {
+        });
+    }
+
+    function onKeybindingProfileChanged() {
+        if (suppressEvents) { return; }
+        var value = this.value;
+        requestHTTP("PUT", "/keybinding-profile", value, function onLoad(event, response) {
+            reportMessage("Keybinding profile changed to " + value + ".");
+        }, function onError(event, error) {
+            reportError(error);
+        });
+    }
+
     function doMidiOutputRefresh(quiet) {
         requestHTTP("GET", "/midi-output-device", null, function onLoad(event, response) {
             var list = document.getElementById("midi-output-device");
@@ -127,7 +322,13 @@
                 for (var i = 0; i < devices.length; i++) {
                     addSelectOption(list, devices[i], i);
                 }
-                list.value = response["selected"];
+                if (response["name"] !== "" && !response["connected"]) {
+                    addSelectOption(list, response["name"] + " (disconnected)", "");
+                    list.lastElementChild.disabled = true;
+                    list.value = "";
+                } else {
+                    list.value = response["selected"];
+                }
             } finally {
                 suppressEvents = false;
             }
@@ -154,6 +355,23 @@
         })
     }
 
+    function doMidiOutputClockRefresh() {
+        requestHTTP("GET", "/midi-output-clock", null, function onLoad(event, response) {
+            document.getElementById("midi-output-clock").checked = response["enabled"];
+        }, function onError(event, error) {
+        });
+    }
+
+    function onMidiOutputClockChanged() {
+        if (suppressEvents) { return; }
+        requestHTTP("PUT", "/midi-output-clock", this.checked ? "true" : "false", function onLoad(event, response) {
+            reportMessage("MIDI clock output " + (response["enabled"] ? "enabled" : "disabled") + ".");
+        }, function onError(event, error) {
+            reportError(error);
+            doMidiOutputClockRefresh();
+        });
+    }
+
     var instrumentTable = {
         "0:47": [0, 47, 0],
         "0:1": [0, 1, 12],
@@ -344,19 +562,43 @@
                 doVersionInfoUpdate();
                 doMidiInputRefresh(true);
                 doMidiOutputRefresh(true);
+                doMidiOutputClockRefresh();
+                doKeybindingProfileRefresh();
+                doArpeggiatorRefresh();
+                doMidiActionsRefresh();
                 doSynthInstrumentRefresh();
                 doNTPServerUpdate();
                 doUpdateServerTime();
                 doMIDITrackNumberRefresh();
                 doMIDIOffsetMsRefresh();
+                doMIDIChaseRefresh();
+                doSyncRefresh();
+                doHumanizeRefresh();
+                doMIDITransposeRefresh();
+                doMIDITracksRefresh();
                 doSchedulerRefresh();
+                doPlaybackPauseRefresh();
+                doPlaybackStatusRefresh();
+                doCountInRefresh();
+                doCalendarRefresh();
                 return setTimeout(updateAllStates, 1000, 1);
             case 1:
                 doVersionInfoUpdate();
+                doCalendarRefresh();
                 return setTimeout(updateAllStates, 1000, 2);
             case 2:
-                doMidiInputRefresh(true);
+                if (document.activeElement !== document.getElementById("midi-input-device") && !document.getElementById("midi-input-settings").contains(document.activeElement)) {
+                    doMidiInputRefresh(true);
+                }
                 doMidiOutputRefresh(true);
+                doMidiOutputClockRefresh();
+                if (document.activeElement !== document.getElementById("keybinding-profile")) {
+                    doKeybindingProfileRefresh();
+                }
+                if (["arp-enabled", "arp-pattern", "arp-octaves", "arp-tempo", "arp-division", "arp-latch"].indexOf(document.activeElement.id) === -1) {
+                    doArpeggiatorRefresh();
+                }
+                doMidiActionsRefresh();
                 return setTimeout(updateAllStates, 1000, 3);
             case 3:
                 if (document.activeElement !== document.getElementById("synth-bank") && document.activeElement !== document.getElementById("synth-patch") && document.activeElement !== document.getElementById("synth-transpose")) {
@@ -365,6 +607,7 @@
                 return setTimeout(updateAllStates, 1000, 4);
             case 4:
                 doUpdateServerTime();
+                doPlaybackStatusRefresh();
                 return setTimeout(updateAllStates, 1000, 5);
             case 5:
                 if (document.activeElement !== document.getElementById("midi-track-number")) {
@@ -373,11 +616,29 @@
                 if (document.activeElement !== document.getElementById("midi-offset-ms")) {
                     doMIDIOffsetMsRefresh();
                 }
+                if (document.activeElement !== document.getElementById("midi-chase")) {
+                    doMIDIChaseRefresh();
+                }
+                if (document.activeElement !== document.getElementById("sched-sync")) {
+                    doSyncRefresh();
+                }
+                if (document.activeElement !== document.getElementById("midi-transpose-semitones") && document.activeElement !== document.getElementById("midi-transpose-octaves")) {
+                    doMIDITransposeRefresh();
+                }
+                if (document.activeElement !== document.getElementById("midi-tracks")) {
+                    doMIDITracksRefresh();
+                }
+                if (document.activeElement !== document.getElementById("humanize-jitter") && document.activeElement !== document.getElementById("humanize-emphasis") && document.activeElement !== document.getElementById("humanize-seed")) {
+                    doHumanizeRefresh();
+                }
                 return setTimeout(updateAllStates, 1000, 6);
             case 6:
                 if (document.activeElement !== document.getElementById("sched-start-time") && document.activeElement !== document.getElementById("sched-loop-interval")) {
                     doSchedulerRefresh();
                 }
+                doPlaybackPauseRefresh();
+                doPlaybackStatusRefresh();
+                doCountInRefresh();
                 return setTimeout(updateAllStates, 1000, 1);
         }
     }
@@ -418,6 +679,9 @@
             var file = this.files[0];
             requestHTTP("PUT", "/midi-playback-file", file, function onLoad(event, response) {
                 reportMessage("MIDI file loaded: " + file.name);
+                doHumanizeRefresh();
+                doMIDITransposeRefresh();
+                doMIDITracksRefresh();
             }, function onError(event, error) {
                 reportError(error);
             });
@@ -441,6 +705,123 @@
         })
     }
 
+    function doMIDIChaseRefresh() {
+        requestHTTP("GET", "/midi-playback-chase", null, function onLoad(event, response) {
+            document.getElementById("midi-chase").value = response["selected"];
+        }, function onError(event, error) {
+        });
+    }
+
+    function onMIDIChaseChanged() {
+        if (suppressEvents) { return; }
+        var value = this.value;
+        requestHTTP("PUT", "/midi-playback-chase", value, function onLoad(event, response) {
+            reportMessage("Articulation profile changed to " + value + ".");
+        }, function onError(event, error) {
+            reportError(error);
+        });
+    }
+
+    function doSyncRefresh() {
+        requestHTTP("GET", "/midi-playback-sync", null, function onLoad(event, response) {
+            document.getElementById("sched-sync").value = response["selected"];
+        }, function onError(event, error) {
+        });
+    }
+
+    function onSyncChanged() {
+        if (suppressEvents) { return; }
+        var el = this;
+        var text = el.options[el.selectedIndex].text;
+        requestHTTP("PUT", "/midi-playback-sync", el.value, function onLoad(event, response) {
+            reportMessage("Playback follows: " + text + ".");
+        }, function onError(event, error) {
+            reportError(error);
+            doSyncRefresh();
+        });
+    }
+
+    function doHumanizeRefresh() {
+        requestHTTP("GET", "/midi-playback-humanize", null, function onLoad(event, response) {
+            document.getElementById("humanize-enabled").checked = response["enabled"];
+            document.getElementById("humanize-jitter").value = Math.round(response["jitter"] * 1000);
+            document.getElementById("humanize-emphasis").value = response["emphasis"];
+            document.getElementById("humanize-seed").value = response["seed"];
+        }, function onError(event, error) {
+        });
+    }
+
+    function onHumanizeChanged() {
+        if (suppressEvents) { return; }
+        var settings = {
+            "enabled": document.getElementById("humanize-enabled").checked,
+            "jitter": (document.getElementById("humanize-jitter").value || 0) * 0.001,
+            "emphasis": parseInt(document.getElementById("humanize-emphasis").value || "0", 10),
+            "seed": parseInt(document.getElementById("humanize-seed").value || "0", 10)
+        };
+        requestHTTP("PUT", "/midi-playback-humanize", JSON.stringify(settings), function onLoad(event, response) {
+            reportMessage("Humanization " + (response["enabled"] ? "enabled" : "disabled") + " for this song.");
+        }, function onError(event, error) {
+            reportError(error);
+            doHumanizeRefresh();
+        });
+    }
+
+    function doMIDITracksRefresh() {
+        requestHTTP("GET", "/midi-playback-tracks", null, function onLoad(event, response) {
+            var el = document.getElementById("midi-tracks");
+            var selected = document.getElementById("midi-track-number").value;
+            suppressEvents = true;
+            el.innerHTML = "";
+            response["tracks"].forEach(function (track) {
+                var text = "#" + track["track"] + " " + (track["name"] || "(Untitled)") + ": " + track["notes"] + " notes";
+                if (track["notes"] !== 0) {
+                    text += ", " + track["lowest"] + " \u2013 " + track["highest"];
+                }
+                if (track["out_of_range"] !== 0) {
+                    text += ", " + track["out_of_range"] + " out of range";
+                }
+                var option = document.createElement("option");
+                option.value = track["track"];
+                option.innerText = text;
+                el.appendChild(option);
+            });
+            el.value = selected;
+            suppressEvents = false;
+        }, function onError(event, error) {
+        });
+    }
+
+    function onMIDITracksChanged() {
+        if (suppressEvents) { return; }
+        var el = document.getElementById("midi-track-number");
+        el.value = this.value;
+        onMIDITrackNumberChanged.call(el);
+    }
+
+    function doMIDITransposeRefresh() {
+        requestHTTP("GET", "/midi-playback-transpose", null, function onLoad(event, response) {
+            document.getElementById("midi-transpose-semitones").value = response["semitones"];
+            document.getElementById("midi-transpose-octaves").value = response["octaves"];
+        }, function onError(event, error) {
+        });
+    }
+
+    function onMIDITransposeChanged() {
+        if (suppressEvents) { return; }
+        var settings = {
+            "semitones": parseInt(document.getElementById("midi-transpose-semitones").value || "0", 10),
+            "octaves": parseInt(document.getElementById("midi-transpose-octaves").value || "0", 10)
+        };
+        requestHTTP("PUT", "/midi-playback-transpose", JSON.stringify(settings), function onLoad(event, response) {
+            reportMessage("Playback transposed by " + (response["semitones"] + 12 * response["octaves"]) + " semitones.");
+            doMIDITracksRefresh();
+        }, function onError(event, error) {
+            reportError(error);
+            doMIDITransposeRefresh();
+        });
+    }
+
     function doMIDIOffsetMsRefresh() {
         requestHTTP("GET", "/midi-playback-offset", null, function onLoad(event, response) {
             document.getElementById("midi-offset-ms").value = Math.round(response["offset"] * 1000);
@@ -458,6 +839,7 @@
     }
 
     var schedulerEnabled = false;
+    var schedulerStartTime = null;
 
     function doSchedulerRefresh() {
         requestHTTP("GET", "/scheduler", null, function onLoad(event, response) {
@@ -467,6 +849,7 @@
             } else {
                 document.getElementById("sched-set").classList.remove("pure-button-primary");
             }
+            schedulerStartTime = response["start_time"];
             var startTime = response["start_time"] !== null ? new Date(response["start_time"] * 1000) : null;
             if (startTime !== null) {
                 var startTimeHours = startTime.getHours();
@@ -564,10 +947,182 @@
         });
     }
 
+    function formatDateTime(date) {
+        var month = date.getMonth() + 1;
+        var day = date.getDate();
+        var hours = date.getHours();
+        var minutes = date.getMinutes();
+        var seconds = date.getSeconds();
+        month = month < 10 ? "0" + month : "" + month;
+        day = day < 10 ? "0" + day : "" + day;
+        hours = hours < 10 ? "0" + hours : "" + hours;
+        minutes = minutes < 10 ? "0" + minutes : "" + minutes;
+        seconds = seconds < 10 ? "0" + seconds : "" + seconds;
+        return date.getFullYear() + "-" + month + "-" + day + " " + hours + " : " + minutes + " : " + seconds;
+    }
+
+    function doCalendarRefresh() {
+        requestHTTP("GET", "/calendar", null, function onLoad(event, response) {
+            var next = response["next"];
+            if (next !== null) {
+                document.getElementById("calendar-next").value = formatDateTime(new Date(next["start_time"] * 1000)) + " " + next["name"];
+            } else {
+                document.getElementById("calendar-next").value = "";
+            }
+            var el = document.getElementById("calendar-entries");
+            if (document.activeElement !== el) {
+                el.value = response["entries"].length !== 0 ? JSON.stringify(response["entries"], null, 2) : "";
+            }
+        }, function onError(event, error) {
+        });
+    }
+
+    function onCalendarSaveClicked() {
+        var value = document.getElementById("calendar-entries").value.trim() || "[]";
+        try {
+            JSON.parse(value);
+        } catch (e) {
+            reportError("Invalid calendar: " + e.message);
+            return;
+        }
+        requestHTTP("PUT", "/calendar", value, function onLoad(event, response) {
+            document.getElementById("calendar-entries").blur();
+            reportMessage("Calendar saved, " + response["entries"].length + " entries.");
+            doCalendarRefresh();
+        }, function onError(event, error) {
+            reportError(error);
+        });
+    }
+
+    var countIn = {
+        "bars": 0,
+        "beats_per_bar": 4,
+        "beat_duration": 0.5,
+    };
+
+    function displayCountIn() {
+        var el = document.getElementById("sched-count-in-beat");
+        var text = "-";
+        var active = false;
+        var downbeat = false;
+        var totalDuration = countIn["bars"] * countIn["beats_per_bar"] * countIn["beat_duration"];
+        if (schedulerEnabled && !playbackPaused && schedulerStartTime !== null && totalDuration > 0) {
+            var now = Date.now() * 0.001 + serverTime["offset"];
+            var elapsed = totalDuration - (schedulerStartTime - now);
+            if (elapsed >= 0 && elapsed < totalDuration) {
+                var beat = Math.floor(elapsed / countIn["beat_duration"]);
+                var bar = Math.floor(beat / countIn["beats_per_bar"]);
+                beat %= countIn["beats_per_bar"];
+                text = (bar + 1) + " : " + (beat + 1);
+                active = elapsed % countIn["beat_duration"] < countIn["beat_duration"] * 0.25;
+                downbeat = active && beat === 0;
+            }
+        }
+        el.value = text;
+        el.classList.toggle("count-in-beat", active && !downbeat);
+        el.classList.toggle("count-in-downbeat", downbeat);
+        requestAnimationFrame(displayCountIn);
+    }
+
+    function doCountInRefresh() {
+        requestHTTP("GET", "/midi-playback-count-in", null, function onLoad(event, response) {
+            countIn = response;
+            if (document.activeElement !== document.getElementById("sched-count-in")) {
+                document.getElementById("sched-count-in").value = response["bars"];
+            }
+        }, function onError(event, error) {
+        });
+    }
+
+    function onCountInChanged() {
+        if (suppressEvents) { return; }
+        var value = this.value || "0";
+        requestHTTP("PUT", "/midi-playback-count-in", value, function onLoad(event, response) {
+            countIn = response;
+            reportMessage("Count-in changed to " + value + " bars.");
+        }, function onError(event, error) {
+            reportError(error);
+        });
+    }
+
+    var playbackPaused = false;
+
+    function displayPlaybackPaused() {
+        var button = document.getElementById("sched-pause");
+        if (playbackPaused) {
+            button.value = "Resume";
+            button.classList.add("pure-button-primary");
+        } else {
+            button.value = "Pause";
+            button.classList.remove("pure-button-primary");
+        }
+    }
+
+    function doPlaybackPauseRefresh() {
+        requestHTTP("GET", "/midi-playback-pause", null, function onLoad(event, response) {
+            playbackPaused = response["paused"];
+            displayPlaybackPaused();
+        }, function onError(event, error) {
+        });
+    }
+
+    function formatDuration(seconds) {
+        seconds = Math.max(0, Math.floor(seconds));
+        var minutes = Math.floor(seconds / 60);
+        seconds %= 60;
+        return minutes + ":" + (seconds < 10 ? "0" : "") + seconds;
+    }
+
+    function doPlaybackStatusRefresh() {
+        requestHTTP("GET", "/playback-status", null, function onLoad(event, response) {
+            var position = response["state"];
+            if (response["state"] !== "stopped") {
+                position += ", " + formatDuration(response["elapsed"]) + " / -" + formatDuration(response["remaining"]);
+                if (response["bar"] !== 0) {
+                    position += ", bar " + response["bar"] + " : " + response["beat"];
+                }
+                position += ", " + Math.round(response["tempo"]) + " BPM";
+                if (response["loop_iteration"] !== 0) {
+                    position += ", loop #" + response["loop_iteration"];
+                }
+                if (response["next_note"] !== null) {
+                    position += ", next " + response["next_note"] + " in " + response["next_note_in"].toFixed(1) + "s";
+                }
+            }
+            document.getElementById("playback-status-position").value = position;
+            var counters = "Sent " + response["notes_sent"] + ", delayed " + response["notes_delayed"] + ", dropped " + response["notes_dropped"];
+            if (response["rate_limit_delayed"] !== 0 || response["rate_limit_dropped"] !== 0) {
+                counters += ", rate limited " + (response["rate_limit_delayed"] + response["rate_limit_dropped"]);
+            }
+            document.getElementById("playback-status-counters").value = counters;
+        }, function onError(event, error) {
+        });
+    }
+
+    function onPlaybackPauseClicked() {
+        var value = !playbackPaused;
+        requestHTTP("PUT", "/midi-playback-pause", value ? "true" : "false", function onLoad(event, response) {
+            playbackPaused = response["paused"];
+            displayPlaybackPaused();
+            if (playbackPaused) {
+                reportMessage("Playback paused at " + response["position"].toFixed(3) + " seconds.");
+            } else {
+                reportMessage("Playback resumed.");
+            }
+        }, function onError(event, error) {
+            reportError(error);
+        });
+    }
+
     document.getElementById("midi-input-refresh").addEventListener("click", onMidiInputRefreshClicked);
     document.getElementById("midi-input-device").addEventListener("change", onMidiInputDeviceChanged);
+    document.getElementById("keybinding-profile").addEventListener("change", onKeybindingProfileChanged);
+    ["arp-enabled", "arp-pattern", "arp-octaves", "arp-tempo", "arp-division", "arp-latch"].forEach(function (id) {
+        document.getElementById(id).addEventListener("change", onArpeggiatorChanged);
+    });
     document.getElementById("midi-output-refresh").addEventListener("click", onMidiOutputRefreshClicked);
     document.getElementById("midi-output-device").addEventListener("change", onMidiOutputDeviceChanged);
+    document.getElementById("midi-output-clock").addEventListener("change", onMidiOutputClockChanged);
     document.getElementById("synth-bank").addEventListener("change", onSynthBankChanged);
     document.getElementById("synth-patch").addEventListener("change", onSynthPatchChanged);
     document.getElementById("synth-transpose").addEventListener("change", onSynthTransposeChanged);
@@ -577,13 +1132,26 @@
     document.getElementById("midi-file").addEventListener("change", onMIDIFileChanged);
     document.getElementById("midi-track-number").addEventListener("change", onMIDITrackNumberChanged);
     document.getElementById("midi-offset-ms").addEventListener("change", onMIDIOffsetMsChanged);
+    document.getElementById("midi-transpose-semitones").addEventListener("change", onMIDITransposeChanged);
+    document.getElementById("midi-transpose-octaves").addEventListener("change", onMIDITransposeChanged);
+    document.getElementById("midi-tracks").addEventListener("change", onMIDITracksChanged);
+    document.getElementById("midi-chase").addEventListener("change", onMIDIChaseChanged);
+    document.getElementById("sched-sync").addEventListener("change", onSyncChanged);
+    document.getElementById("humanize-enabled").addEventListener("change", onHumanizeChanged);
+    document.getElementById("humanize-jitter").addEventListener("change", onHumanizeChanged);
+    document.getElementById("humanize-emphasis").addEventListener("change", onHumanizeChanged);
+    document.getElementById("humanize-seed").addEventListener("change", onHumanizeChanged);
     document.getElementById("sched-start-time").addEventListener("change", onSchedulerChanged);
     document.getElementById("sched-set").addEventListener("click", onSchedulerChanged);
     document.getElementById("sched-loop-enabled").addEventListener("change", onSchedulerChanged);
     document.getElementById("sched-loop-interval").addEventListener("change", onSchedulerChanged);
+    document.getElementById("sched-count-in").addEventListener("change", onCountInChanged);
+    document.getElementById("sched-pause").addEventListener("click", onPlaybackPauseClicked);
+    document.getElementById("calendar-save").addEventListener("click", onCalendarSaveClicked);
 
     document.getElementById("midi-file").value = "";
     updateAllStates(0);
     requestAnimationFrame(displayServerTime);
+    requestAnimationFrame(displayCountIn);
 
 })();